@@ -638,7 +638,11 @@ func (s *sock) isPacketBased() bool {
 
 // Readiness returns a mask of ready events for socket s.
 func (s *sock) Readiness(mask waiter.EventMask) waiter.EventMask {
-	return s.Endpoint.Readiness(mask)
+	ready := s.Endpoint.Readiness(mask)
+	if mask&waiter.EventPri != 0 && s.Endpoint.SocketOptions().SelectErrQueueReady() {
+		ready |= waiter.EventPri
+	}
+	return ready
 }
 
 // checkFamily returns true iff the specified address family may be used with
@@ -878,8 +882,35 @@ func boolToInt32(v bool) int32 {
 	return 0
 }
 
+// socketBoolOptions maps the boolean SOL_SOCKET options to the options that
+// tcpip.SocketOptions.SetBool and tcpip.SocketOptions.GetBool handle them as.
+var socketBoolOptions = map[int]tcpip.SockOptBool{
+	linux.SO_BROADCAST:        tcpip.BroadcastOption,
+	linux.SO_KEEPALIVE:        tcpip.KeepAliveOption,
+	linux.SO_LOCK_FILTER:      tcpip.LockFilterOption,
+	linux.SO_NO_CHECK:         tcpip.NoChecksumOption,
+	linux.SO_OOBINLINE:        tcpip.OutOfBandInlineOption,
+	linux.SO_PASSCRED:         tcpip.PassCredOption,
+	linux.SO_REUSEADDR:        tcpip.ReuseAddressOption,
+	linux.SO_REUSEPORT:        tcpip.ReusePortOption,
+	linux.SO_SELECT_ERR_QUEUE: tcpip.SelectErrQueueOption,
+}
+
 // getSockOptSocket implements GetSockOpt when level is SOL_SOCKET.
 func getSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, family int, _ linux.SockType, name, outLen int) (marshal.Marshallable, *syserr.Error) {
+	if opt, ok := socketBoolOptions[name]; ok {
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.SocketOptions().GetBool(opt)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(boolToInt32(v))
+		return &vP, nil
+	}
+
 	// TODO(b/124056281): Stop rejecting short optLen values in getsockopt.
 	switch name {
 	case linux.SO_ERROR:
@@ -910,12 +941,21 @@ func getSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, family
 		}
 		return &creds, nil
 
-	case linux.SO_PASSCRED:
-		if outLen < sizeOfInt32 {
-			return nil, syserr.ErrInvalidArgument
+	case linux.SO_PASSSEC:
+		// No receive path produces SCM_SECURITY yet, so don't advertise
+		// support.
+		return nil, syserr.ErrUnknownProtocolOption
+
+	case linux.SO_PEERSEC:
+		secCtx, err := ep.SocketOptions().GetPeerSec()
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		if outLen < len(secCtx) {
+			return nil, syserr.ErrRange
 		}
 
-		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetPassCred()))
+		v := primitive.ByteSlice(secCtx)
 		return &v, nil
 
 	case linux.SO_SNDBUF:
@@ -946,22 +986,6 @@ func getSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, family
 		sizeP := primitive.Int32(size)
 		return &sizeP, nil
 
-	case linux.SO_REUSEADDR:
-		if outLen < sizeOfInt32 {
-			return nil, syserr.ErrInvalidArgument
-		}
-
-		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReuseAddress()))
-		return &v, nil
-
-	case linux.SO_REUSEPORT:
-		if outLen < sizeOfInt32 {
-			return nil, syserr.ErrInvalidArgument
-		}
-
-		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReusePort()))
-		return &v, nil
-
 	case linux.SO_BINDTODEVICE:
 		v := ep.SocketOptions().GetBindToDevice()
 		if v == 0 {
@@ -985,22 +1009,6 @@ func getSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, family
 		name := primitive.ByteSlice(append([]byte(nic.Name), 0))
 		return &name, nil
 
-	case linux.SO_BROADCAST:
-		if outLen < sizeOfInt32 {
-			return nil, syserr.ErrInvalidArgument
-		}
-
-		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetBroadcast()))
-		return &v, nil
-
-	case linux.SO_KEEPALIVE:
-		if outLen < sizeOfInt32 {
-			return nil, syserr.ErrInvalidArgument
-		}
-
-		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetKeepAlive()))
-		return &v, nil
-
 	case linux.SO_LINGER:
 		if outLen < linux.SizeOfLinger {
 			return nil, syserr.ErrInvalidArgument
@@ -1033,22 +1041,6 @@ func getSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, family
 		recvTimeout := linux.NsecToTimeval(s.RecvTimeout())
 		return &recvTimeout, nil
 
-	case linux.SO_OOBINLINE:
-		if outLen < sizeOfInt32 {
-			return nil, syserr.ErrInvalidArgument
-		}
-
-		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetOutOfBandInline()))
-		return &v, nil
-
-	case linux.SO_NO_CHECK:
-		if outLen < sizeOfInt32 {
-			return nil, syserr.ErrInvalidArgument
-		}
-
-		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetNoChecksum()))
-		return &v, nil
-
 	case linux.SO_ACCEPTCONN:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1604,6 +1596,14 @@ func getSockOptIP(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int,
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetMulticastLoop()))
 		return &v, nil
 
+	case linux.IP_MULTICAST_ALL:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetMulticastAll()))
+		return &v, nil
+
 	case linux.IP_TOS:
 		// Length handling for parity with Linux.
 		if outLen == 0 {
@@ -1637,6 +1637,18 @@ func getSockOptIP(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int,
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetIPv4RecvError()))
 		return &v, nil
 
+	case linux.IP_MTU:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		mtu, err := ep.SocketOptions().GetPathMTU()
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		v := primitive.Int32(mtu)
+		return &v, nil
+
 	case linux.IP_PKTINFO:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1770,28 +1782,17 @@ func SetSockOpt(t *kernel.Task, s socket.Socket, ep commonEndpoint, level int, n
 	return nil
 }
 
-func clampBufSize(newSz, min, max int64, ignoreMax bool) int64 {
-	// packetOverheadFactor is used to multiply the value provided by the user on
-	// a setsockopt(2) for setting the send/receive buffer sizes sockets.
-	const packetOverheadFactor = 2
-
-	if !ignoreMax && newSz > max {
-		newSz = max
-	}
-
-	if newSz < math.MaxInt32/packetOverheadFactor {
-		newSz *= packetOverheadFactor
-		if newSz < min {
-			newSz = min
+// setSockOptSocket implements SetSockOpt when level is SOL_SOCKET.
+func setSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
+	if opt, ok := socketBoolOptions[name]; ok {
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
 		}
-	} else {
-		newSz = math.MaxInt32
+
+		v := hostarch.ByteOrder.Uint32(optVal)
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetBool(opt, v != 0))
 	}
-	return newSz
-}
 
-// setSockOptSocket implements SetSockOpt when level is SOL_SOCKET.
-func setSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
 	switch name {
 	case linux.SO_SNDBUF:
 		if len(optVal) < sizeOfInt32 {
@@ -1799,10 +1800,7 @@ func setSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, name i
 		}
 
 		v := hostarch.ByteOrder.Uint32(optVal)
-		min, max := ep.SocketOptions().SendBufferLimits()
-		clamped := clampBufSize(int64(v), min, max, false /* ignoreMax */)
-		ep.SocketOptions().SetSendBufferSize(clamped, true /* notify */)
-		return nil
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetInt(tcpip.SocketSendBufferSizeOption, int64(v)))
 
 	case linux.SO_RCVBUF:
 		if len(optVal) < sizeOfInt32 {
@@ -1810,10 +1808,7 @@ func setSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, name i
 		}
 
 		v := hostarch.ByteOrder.Uint32(optVal)
-		min, max := ep.SocketOptions().ReceiveBufferLimits()
-		clamped := clampBufSize(int64(v), min, max, false /* ignoreMax */)
-		ep.SocketOptions().SetReceiveBufferSize(clamped, true /* notify */)
-		return nil
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetInt(tcpip.SocketReceiveBufferSizeOption, int64(v)))
 
 	case linux.SO_RCVBUFFORCE:
 		if len(optVal) < sizeOfInt32 {
@@ -1825,28 +1820,7 @@ func setSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, name i
 		}
 
 		v := hostarch.ByteOrder.Uint32(optVal)
-		min, max := ep.SocketOptions().ReceiveBufferLimits()
-		clamped := clampBufSize(int64(v), min, max, true /* ignoreMax */)
-		ep.SocketOptions().SetReceiveBufferSize(clamped, true /* notify */)
-		return nil
-
-	case linux.SO_REUSEADDR:
-		if len(optVal) < sizeOfInt32 {
-			return syserr.ErrInvalidArgument
-		}
-
-		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetReuseAddress(v != 0)
-		return nil
-
-	case linux.SO_REUSEPORT:
-		if len(optVal) < sizeOfInt32 {
-			return syserr.ErrInvalidArgument
-		}
-
-		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetReusePort(v != 0)
-		return nil
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetInt(tcpip.SocketReceiveBufferSizeForceOption, int64(v)))
 
 	case linux.SO_BINDTODEVICE:
 		n := bytes.IndexByte(optVal, 0)
@@ -1868,32 +1842,10 @@ func setSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, name i
 		}
 		return syserr.ErrUnknownDevice
 
-	case linux.SO_BROADCAST:
-		if len(optVal) < sizeOfInt32 {
-			return syserr.ErrInvalidArgument
-		}
-
-		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetBroadcast(v != 0)
-		return nil
-
-	case linux.SO_PASSCRED:
-		if len(optVal) < sizeOfInt32 {
-			return syserr.ErrInvalidArgument
-		}
-
-		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetPassCred(v != 0)
-		return nil
-
-	case linux.SO_KEEPALIVE:
-		if len(optVal) < sizeOfInt32 {
-			return syserr.ErrInvalidArgument
-		}
-
-		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetKeepAlive(v != 0)
-		return nil
+	case linux.SO_PASSSEC:
+		// No receive path produces SCM_SECURITY yet, so don't advertise
+		// support.
+		return syserr.ErrUnknownProtocolOption
 
 	case linux.SO_SNDTIMEO:
 		if len(optVal) < linux.SizeOfTimeval {
@@ -1921,24 +1873,6 @@ func setSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, name i
 		s.SetRecvTimeout(v.ToNsecCapped())
 		return nil
 
-	case linux.SO_OOBINLINE:
-		if len(optVal) < sizeOfInt32 {
-			return syserr.ErrInvalidArgument
-		}
-
-		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetOutOfBandInline(v != 0)
-		return nil
-
-	case linux.SO_NO_CHECK:
-		if len(optVal) < sizeOfInt32 {
-			return syserr.ErrInvalidArgument
-		}
-
-		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetNoChecksum(v != 0)
-		return nil
-
 	case linux.SO_LINGER:
 		if len(optVal) < linux.SizeOfLinger {
 			return syserr.ErrInvalidArgument
@@ -1953,10 +1887,16 @@ func setSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, name i
 		})
 		return nil
 
+	case linux.SO_ATTACH_FILTER:
+		prog, err := copyInSockFilter(t, optVal)
+		if err != nil {
+			return err
+		}
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetAttachFilter(prog))
+
 	case linux.SO_DETACH_FILTER:
 		// optval is ignored.
-		var v tcpip.SocketDetachFilterOption
-		return syserr.TranslateNetstackError(ep.SetSockOpt(&v))
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetDetachFilter())
 
 	// TODO(b/226603727): Add support for SO_RCVLOWAT option. For now, only
 	// the unsupported syscall message is removed.
@@ -1973,6 +1913,33 @@ func setSockOptSocket(t *kernel.Task, s socket.Socket, ep commonEndpoint, name i
 	return nil
 }
 
+// sizeOfSockFprog is the size of struct sock_fprog on 64-bit platforms.
+const sizeOfSockFprog = 16
+
+// copyInSockFilter copies in the classic BPF program described by the struct
+// sock_fprog in optVal.
+func copyInSockFilter(t *kernel.Task, optVal []byte) ([]tcpip.SockFilter, *syserr.Error) {
+	if len(optVal) < sizeOfSockFprog {
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	n := int(hostarch.ByteOrder.Uint16(optVal))
+	if n == 0 || n > tcpip.MaxSockFilterLen {
+		return nil, syserr.ErrInvalidArgument
+	}
+	addr := hostarch.Addr(hostarch.ByteOrder.Uint64(optVal[8:]))
+	insns := make([]linux.BPFInstruction, n)
+	if _, err := linux.CopyBPFInstructionSliceIn(t, addr, insns); err != nil {
+		return nil, syserr.FromError(err)
+	}
+
+	prog := make([]tcpip.SockFilter, n)
+	for i, insn := range insns {
+		prog[i] = tcpip.SockFilter(insn)
+	}
+	return prog, nil
+}
+
 // setSockOptTCP implements SetSockOpt when level is SOL_TCP.
 func setSockOptTCP(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
 	if !socket.IsTCP(s) {
@@ -1986,8 +1953,7 @@ func setSockOptTCP(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int,
 		}
 
 		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetDelayOption(v == 0)
-		return nil
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetDelayOption(v == 0))
 
 	case linux.TCP_CORK:
 		if len(optVal) < sizeOfInt32 {
@@ -1995,8 +1961,7 @@ func setSockOptTCP(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int,
 		}
 
 		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetCorkOption(v != 0)
-		return nil
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetCorkOption(v != 0))
 
 	case linux.TCP_QUICKACK:
 		if len(optVal) < sizeOfInt32 {
@@ -2004,8 +1969,7 @@ func setSockOptTCP(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int,
 		}
 
 		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetQuickAck(v != 0)
-		return nil
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetQuickAck(v != 0))
 
 	case linux.TCP_MAXSEG:
 		if len(optVal) < sizeOfInt32 {
@@ -2163,15 +2127,8 @@ func setSockOptIPv6(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int
 			return syserr.ErrInvalidArgument
 		}
 
-		if socket.IsTCP(s) && tcp.EndpointState(ep.State()) != tcp.StateInitial {
-			return syserr.ErrInvalidEndpointState
-		} else if socket.IsUDP(s) && transport.DatagramEndpointState(ep.State()) != transport.DatagramEndpointStateInitial {
-			return syserr.ErrInvalidEndpointState
-		}
-
 		v := hostarch.ByteOrder.Uint32(optVal)
-		ep.SocketOptions().SetV6Only(v != 0)
-		return nil
+		return syserr.TranslateNetstackError(ep.SocketOptions().SetV6Only(v != 0))
 
 	case linux.IPV6_ADD_MEMBERSHIP:
 		req, err := copyInMulticastV6Request(optVal)
@@ -2437,6 +2394,18 @@ func setSockOptIP(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int,
 		ep.SocketOptions().SetMulticastLoop(v != 0)
 		return nil
 
+	case linux.IP_MULTICAST_ALL:
+		v, err := parseIntOrChar(optVal)
+		if err != nil {
+			return err
+		}
+		if v != 0 && v != 1 {
+			return syserr.ErrInvalidArgument
+		}
+
+		ep.SocketOptions().SetMulticastAll(v != 0)
+		return nil
+
 	case linux.MCAST_JOIN_GROUP:
 		// FIXME(b/124219304): Implement MCAST_JOIN_GROUP.
 		return syserr.ErrInvalidArgument
@@ -2557,7 +2526,6 @@ func setSockOptIP(t *kernel.Task, s socket.Socket, ep commonEndpoint, name int,
 		linux.IP_MINTTL,
 		linux.IP_MSFILTER,
 		linux.IP_MTU_DISCOVER,
-		linux.IP_MULTICAST_ALL,
 		linux.IP_NODEFRAG,
 		linux.IP_OPTIONS,
 		linux.IP_PASSSEC,
@@ -2815,8 +2783,11 @@ func (s *sock) recvErr(t *kernel.Task, dst usermem.IOSequence) (int, int, linux.
 
 	// The payload of the original packet that caused the error is passed as
 	// normal data via msg_iovec.  -- recvmsg(2)
+	//
+	// MSG_TRUNC is based on the original payload length, which may exceed the
+	// stored payload if it was capped when queued.
 	msgFlags := linux.MSG_ERRQUEUE
-	if int(dst.NumBytes()) < sockErr.Payload.Size() {
+	if int(dst.NumBytes()) < sockErr.OriginalPayloadLen || sockErr.Payload.Size() < sockErr.OriginalPayloadLen {
 		msgFlags |= linux.MSG_TRUNC
 	}
 	n, err := dst.CopyOut(t, sockErr.Payload.AsSlice())
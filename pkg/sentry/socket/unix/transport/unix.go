@@ -1052,6 +1052,15 @@ func (h *stackHandler) TransportProtocolOption(proto tcpip.TransportProtocolNumb
 	panic("unimplemented")
 }
 
+// stdClock timestamps errors queued on AF_UNIX sockets, which have no
+// networking stack to provide a clock.
+var stdClock = tcpip.NewStdClock()
+
+// Clock implements tcpip.StackHandler.
+func (h *stackHandler) Clock() tcpip.Clock {
+	return stdClock
+}
+
 // getSendBufferLimits implements tcpip.GetSendBufferLimits.
 //
 // AF_UNIX sockets buffer sizes are not tied to the networking stack/namespace
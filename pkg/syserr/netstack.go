@@ -127,6 +127,8 @@ func TranslateNetstackError(err tcpip.Error) *Error {
 		return ErrInvalidOptionValue
 	case *tcpip.ErrBadAddress:
 		return ErrBadAddress
+	case *tcpip.ErrNetworkDown:
+		return ErrNetworkDown
 	case *tcpip.ErrNetworkUnreachable:
 		return ErrNetworkUnreachable
 	case *tcpip.ErrMessageTooLong:
@@ -340,6 +340,20 @@ func (*ErrMessageTooLong) IgnoreStats() bool {
 }
 func (*ErrMessageTooLong) String() string { return "message too long" }
 
+// ErrNetworkDown indicates the network interface the operation relied on is
+// gone.
+//
+// +stateify savable
+type ErrNetworkDown struct{}
+
+func (*ErrNetworkDown) isError() {}
+
+// IgnoreStats implements Error.
+func (*ErrNetworkDown) IgnoreStats() bool {
+	return false
+}
+func (*ErrNetworkDown) String() string { return "network is down" }
+
 // ErrNetworkUnreachable indicates the operation is not able to reach the
 // destination network.
 //
@@ -39,8 +39,9 @@ const (
 // Example shows how to interact with a multicast RouteTable.
 func Example() {
 	address := testutil.MustParse4("192.168.1.1")
+	multicastAddress := testutil.MustParse4("224.0.1.1")
 	defaultOutgoingInterfaces := []stack.MulticastRouteOutgoingInterface{{ID: outgoingNICID, MinTTL: defaultMinTTL}}
-	routeKey := stack.UnicastSourceAndMulticastDestination{Source: address, Destination: address}
+	routeKey := stack.UnicastSourceAndMulticastDestination{Source: address, Destination: multicastAddress}
 	multicastRoute := stack.MulticastRoute{inputNICID, defaultOutgoingInterfaces}
 
 	pkt := newPacketBuffer("hello")
@@ -60,11 +61,11 @@ func Example() {
 
 	// Each entry in the table represents either an installed route or a pending
 	// route. To insert a pending route, call:
-	result, hasBufferSpace := table.GetRouteOrInsertPending(routeKey, pkt)
+	result, err := table.GetRouteOrInsertPending(routeKey, pkt)
 
 	// Callers should handle a no buffer space error (e.g. only deliver the
 	// packet locally).
-	if !hasBufferSpace {
+	if err == multicast.ErrNoBufferSpace {
 		deliverPktLocally(pkt)
 	}
 
@@ -19,14 +19,68 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"gvisor.dev/gvisor/pkg/atomicbitops"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
+// numRouteTableShards is the number of shards the routes of a RouteTable are
+// spread across.
+const numRouteTableShards = 16
+
 // RouteTable represents a multicast routing table.
+//
+// Routes are sharded by their multicast group so that operations on routes
+// for different groups do not contend on the same locks. All routes for a
+// group, including its (*, G) route, are held by the same shard.
 type RouteTable struct {
+	shards [numRouteTableShards]routeTableShard
+
+	// pendingRouteCount and installedRouteCount are the number of pending and
+	// installed routes across all shards. They are used to decide whether the
+	// cleanup routine needs to keep running.
+	pendingRouteCount   atomicbitops.Int64
+	installedRouteCount atomicbitops.Int64
+
+	// pendingPacketCount is the number of packets queued across all pending
+	// routes.
+	pendingPacketCount atomicbitops.Int64
+
+	// pendingOrderMu must be acquired after any shard locks.
+	pendingOrderMu sync.Mutex
+	// oldestQueuedPacket and newestQueuedPacket are the ends of a list holding
+	// the packets queued across all pending routes in the order they were
+	// queued. The list is only maintained if Config.PendingPacketBudget is set.
+	// +checklocks:pendingOrderMu
+	oldestQueuedPacket *queuedPacket
+	// +checklocks:pendingOrderMu
+	newestQueuedPacket *queuedPacket
+
+	// closed indicates that Close was invoked. Once closed, no packets are
+	// queued and the cleanup routine is never restarted.
+	closed atomicbitops.Bool
+
+	// cleanupMu must be acquired after any shard locks.
+	cleanupMu sync.Mutex
+	// +checklocks:cleanupMu
+	initialized bool
+	// cleanupPendingRoutesTimer is a timer that triggers a routine to remove
+	// pending routes that are expired.
+	// +checklocks:cleanupMu
+	cleanupPendingRoutesTimer tcpip.Timer
+	// +checklocks:cleanupMu
+	isCleanupRoutineRunning bool
+
+	config Config
+}
+
+// routeTableShard holds the installed and pending routes of a subset of the
+// multicast groups of a RouteTable.
+type routeTableShard struct {
 	// Internally, installed and pending routes are stored and locked separately
 	// A couple of reasons for structuring the table this way:
 	//
@@ -41,7 +95,8 @@ type RouteTable struct {
 
 	// The installedMu lock should typically be acquired before the pendingMu
 	// lock. This ensures that installed routes can continue to be read even when
-	// the pending routes are write locked.
+	// the pending routes are write locked. When locks of several shards are
+	// held at once, they are acquired in shard order.
 
 	installedMu sync.RWMutex
 	// Maintaining pointers ensures that the installed routes are exclusively
@@ -52,14 +107,18 @@ type RouteTable struct {
 	pendingMu sync.RWMutex
 	// +checklocks:pendingMu
 	pendingRoutes map[stack.UnicastSourceAndMulticastDestination]PendingRoute
-	// cleanupPendingRoutesTimer is a timer that triggers a routine to remove
-	// pending routes that are expired.
-	// +checklocks:pendingMu
-	cleanupPendingRoutesTimer tcpip.Timer
-	// +checklocks:pendingMu
-	isCleanupRoutineRunning bool
+}
 
-	config Config
+// shard returns the shard that holds the routes for key.
+func (r *RouteTable) shard(key stack.UnicastSourceAndMulticastDestination) *routeTableShard {
+	// Only the group is hashed (FNV-1a) so that (S, G) and (*, G) routes share
+	// a shard.
+	h := uint32(2166136261)
+	for i := 0; i < len(key.Destination); i++ {
+		h ^= uint32(key.Destination[i])
+		h *= 16777619
+	}
+	return &r.shards[h%numRouteTableShards]
 }
 
 var (
@@ -73,18 +132,103 @@ var (
 
 	// ErrAlreadyInitialized indicates that RouteTable.Init was already invoked.
 	ErrAlreadyInitialized = errors.New("table is already initialized")
+
+	// ErrNonMulticastDestination indicates that the destination of a route key
+	// is not a multicast address.
+	ErrNonMulticastDestination = errors.New("route destination is not a multicast address")
+
+	// ErrInvalidUnicastSource indicates that the source of a route key is
+	// neither a unicast address nor the wildcard source.
+	ErrInvalidUnicastSource = errors.New("route source is not a unicast address")
+
+	// ErrClosed indicates that RouteTable.Close was invoked, so packets are no
+	// longer queued.
+	ErrClosed = errors.New("table is closed")
 )
 
+// queuedPacket is an entry in the RouteTable's list of queued packets.
+type queuedPacket struct {
+	// key identifies the pending route that the packet is queued in. It is
+	// immutable.
+	key stack.UnicastSourceAndMulticastDestination
+
+	// prev and next are protected by the RouteTable's pendingOrderMu.
+	prev *queuedPacket
+	next *queuedPacket
+}
+
 // InstalledRoute represents a route that is in the installed state.
 //
 // If a route is in the installed state, then it may be used to forward
 // multicast packets.
 type InstalledRoute struct {
-	stack.MulticastRoute
+	// ExpectedInputInterface is the interface that is expected to receive
+	// packets for the route. It is immutable.
+	ExpectedInputInterface tcpip.NICID
+
+	// outgoingInterfaces holds the interfaces that packets are forwarded out
+	// of. It is replaced by RouteTable.UpdateInstalledRoute.
+	outgoingInterfaces atomic.Pointer[[]stack.MulticastRouteOutgoingInterface]
 
 	lastUsedTimestampMu sync.RWMutex
 	// +checklocks:lastUsedTimestampMu
 	lastUsedTimestamp tcpip.MonotonicTime
+
+	statsMu sync.Mutex
+	// droppedBelowMinTTL holds the number of packets that were not forwarded
+	// out of an outgoing interface because their TTL was below the interface's
+	// MinTTL, keyed by the outgoing interface.
+	// +checklocks:statsMu
+	droppedBelowMinTTL map[tcpip.NICID]uint64
+}
+
+// InstalledRouteStats holds the statistics of an InstalledRoute.
+type InstalledRouteStats struct {
+	// DroppedBelowMinTTL holds the number of packets that were not forwarded
+	// out of an outgoing interface because their TTL was below the interface's
+	// MinTTL, keyed by the outgoing interface. Interfaces without drops are
+	// omitted.
+	DroppedBelowMinTTL map[tcpip.NICID]uint64
+}
+
+// OutgoingInterfaces returns the interfaces that packets matching the route
+// should be forwarded out of. The returned slice must not be modified.
+func (r *InstalledRoute) OutgoingInterfaces() []stack.MulticastRouteOutgoingInterface {
+	return *r.outgoingInterfaces.Load()
+}
+
+// Stats returns a snapshot of the route's statistics.
+func (r *InstalledRoute) Stats() InstalledRouteStats {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+
+	stats := InstalledRouteStats{
+		DroppedBelowMinTTL: make(map[tcpip.NICID]uint64, len(r.droppedBelowMinTTL)),
+	}
+	for id, count := range r.droppedBelowMinTTL {
+		stats.DroppedBelowMinTTL[id] = count
+	}
+	return stats
+}
+
+// CheckMinTTL returns true if a packet with the provided ttl may be forwarded
+// out of outgoingInterface.
+//
+// Otherwise, the drop is recorded in the route's stats and false is returned.
+// Callers should invoke this for every outgoing interface a packet is
+// forwarded to.
+func (r *InstalledRoute) CheckMinTTL(outgoingInterface stack.MulticastRouteOutgoingInterface, ttl uint8) bool {
+	if outgoingInterface.MinTTL <= ttl {
+		return true
+	}
+
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	if r.droppedBelowMinTTL == nil {
+		r.droppedBelowMinTTL = make(map[tcpip.NICID]uint64)
+	}
+	r.droppedBelowMinTTL[outgoingInterface.ID]++
+	return false
 }
 
 // LastUsedTimestamp returns a monotonic timestamp that corresponds to the last
@@ -99,8 +243,9 @@ func (r *InstalledRoute) LastUsedTimestamp() tcpip.MonotonicTime {
 // SetLastUsedTimestamp sets the time that the route was last used.
 //
 // The timestamp is only updated if it occurs after the currently set
-// timestamp. Callers should invoke this anytime the route is used to forward a
-// packet.
+// timestamp, so it never decreases, even if the clock steps backwards. Only the
+// route's own lock is held, not a table-wide one. Callers should invoke this
+// anytime the route is used to forward a packet.
 func (r *InstalledRoute) SetLastUsedTimestamp(monotonicTime tcpip.MonotonicTime) {
 	r.lastUsedTimestampMu.Lock()
 	defer r.lastUsedTimestampMu.Unlock()
@@ -118,6 +263,10 @@ func (r *InstalledRoute) SetLastUsedTimestamp(monotonicTime tcpip.MonotonicTime)
 type PendingRoute struct {
 	packets []stack.PacketBufferPtr
 
+	// queued holds the entry of each packet in packets in the table's list of
+	// queued packets. It is only populated if Config.PendingPacketBudget is set.
+	queued []*queuedPacket
+
 	// expiration is the timestamp at which the pending route should be expired.
 	//
 	// If this value is before the current time, then this pending route will
@@ -151,7 +300,7 @@ const (
 	DefaultPendingRouteExpiration time.Duration = 10 * time.Second
 
 	// DefaultCleanupInterval is the default frequency of the routine that
-	// expires pending routes.
+	// expires pending routes and prunes idle installed routes.
 	//
 	// Matches the Linux default:
 	// https://github.com/torvalds/linux/blob/26291c54e11/net/ipv6/ip6mr.c#L793
@@ -170,8 +319,42 @@ type Config struct {
 
 	// Clock represents the clock that should be used to obtain the current time.
 	//
-	// This field is required and must have a non-nil value.
+	// This field is required and must have a non-nil value. Timestamps are
+	// taken from its monotonic time, so wall clock adjustments don't affect
+	// them.
 	Clock tcpip.Clock
+
+	// OnRouteInstalled is an optional callback that is invoked with the key of
+	// a route after it is installed.
+	//
+	// The callback is invoked without holding any table locks, so it may call
+	// back into the table.
+	OnRouteInstalled func(stack.UnicastSourceAndMulticastDestination)
+
+	// OnRouteRemoved is an optional callback that is invoked with the key of
+	// an installed route after it is removed.
+	//
+	// The callback is invoked without holding any table locks, so it may call
+	// back into the table.
+	OnRouteRemoved func(stack.UnicastSourceAndMulticastDestination)
+
+	// PendingPacketBudget is the maximum number of packets that may be queued
+	// across all pending routes.
+	//
+	// If queueing a packet would exceed the budget, then the oldest queued
+	// packet of any pending route is evicted. Unlike MaxPendingQueueSize, this
+	// bounds the memory held by a burst of distinct unresolved routes. A zero
+	// value disables the budget.
+	PendingPacketBudget int
+
+	// MaxIdle is the maximum duration an installed route may go unused before
+	// it is removed by the cleanup routine. Removed routes are reported to
+	// OnRouteRemoved.
+	//
+	// Routes are checked every DefaultCleanupInterval, so a route may remain
+	// installed for up to MaxIdle + DefaultCleanupInterval. A zero value
+	// disables pruning.
+	MaxIdle time.Duration
 }
 
 // DefaultConfig returns the default configuration for the table.
@@ -188,22 +371,30 @@ func DefaultConfig(clock tcpip.Clock) Config {
 //
 // Must be called before any other function on the table.
 func (r *RouteTable) Init(config Config) error {
-	r.installedMu.Lock()
-	defer r.installedMu.Unlock()
-	r.pendingMu.Lock()
-	defer r.pendingMu.Unlock()
-
-	if r.installedRoutes != nil {
+	r.cleanupMu.Lock()
+	if r.initialized {
+		r.cleanupMu.Unlock()
 		return ErrAlreadyInitialized
 	}
 
 	if config.Clock == nil {
+		r.cleanupMu.Unlock()
 		return ErrMissingClock
 	}
 
+	r.initialized = true
 	r.config = config
-	r.installedRoutes = make(map[stack.UnicastSourceAndMulticastDestination]*InstalledRoute)
-	r.pendingRoutes = make(map[stack.UnicastSourceAndMulticastDestination]PendingRoute)
+	r.cleanupMu.Unlock()
+
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.installedMu.Lock()
+		shard.installedRoutes = make(map[stack.UnicastSourceAndMulticastDestination]*InstalledRoute)
+		shard.installedMu.Unlock()
+		shard.pendingMu.Lock()
+		shard.pendingRoutes = make(map[stack.UnicastSourceAndMulticastDestination]PendingRoute)
+		shard.pendingMu.Unlock()
+	}
 
 	return nil
 }
@@ -211,33 +402,75 @@ func (r *RouteTable) Init(config Config) error {
 // Close cleans up resources held by the table.
 //
 // Calling this will stop the cleanup routine and release any packets owned by
-// the table.
+// the table. It is safe to call other methods after Close, but packets are no
+// longer queued in pending routes and GetRouteOrInsertPending returns
+// ErrClosed instead. Close may be called more than once.
 func (r *RouteTable) Close() {
-	r.pendingMu.Lock()
-	defer r.pendingMu.Unlock()
+	// Packets are only queued while holding the shard's pendingMu and after
+	// checking closed, so every packet queued before closed is set is released
+	// below.
+	r.closed.Store(true)
 
+	r.cleanupMu.Lock()
 	if r.cleanupPendingRoutesTimer != nil {
 		r.cleanupPendingRoutesTimer.Stop()
 	}
+	r.isCleanupRoutineRunning = false
+	r.cleanupMu.Unlock()
+
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.pendingMu.Lock()
+		for key, route := range shard.pendingRoutes {
+			delete(shard.pendingRoutes, key)
+			r.pendingRouteCount.Add(-1)
+			r.pendingPacketCount.Add(-int64(len(route.packets)))
+			r.dequeuePendingRoute(&route)
+			route.releasePackets()
+		}
+		shard.pendingMu.Unlock()
+	}
+}
 
-	for key, route := range r.pendingRoutes {
-		delete(r.pendingRoutes, key)
-		route.releasePackets()
+// startCleanupRoutineLocked starts the cleanup routine if it is not already
+// running and the table is not closed.
+//
+// +checklocks:r.cleanupMu
+func (r *RouteTable) startCleanupRoutineLocked() {
+	if r.isCleanupRoutineRunning || r.closed.Load() {
+		return
+	}
+	if r.cleanupPendingRoutesTimer == nil {
+		r.cleanupPendingRoutesTimer = r.config.Clock.AfterFunc(DefaultCleanupInterval, r.cleanupRoutes)
+	} else {
+		r.cleanupPendingRoutesTimer.Reset(DefaultCleanupInterval)
 	}
+	r.isCleanupRoutineRunning = true
+}
+
+// startCleanupRoutine is like startCleanupRoutineLocked, but acquires
+// cleanupMu.
+func (r *RouteTable) startCleanupRoutine() {
+	r.cleanupMu.Lock()
+	defer r.cleanupMu.Unlock()
+	r.startCleanupRoutineLocked()
 }
 
-// maybeStopCleanupRoutine stops the pending routes cleanup routine if no
-// pending routes exist.
+// maybeStopCleanupRoutine stops the cleanup routine if no pending routes
+// exist and there are no installed routes to prune.
+//
+// The route counts must be updated before acquiring cleanupMu, so that a
+// routine that is stopped here is restarted by whoever adds the next route.
 //
 // Returns true if the timer is not running. Otherwise, returns false.
 //
-// +checklocks:r.pendingMu
+// +checklocks:r.cleanupMu
 func (r *RouteTable) maybeStopCleanupRoutineLocked() bool {
 	if !r.isCleanupRoutineRunning {
 		return true
 	}
 
-	if len(r.pendingRoutes) == 0 {
+	if r.pendingRouteCount.Load() == 0 && (r.config.MaxIdle == 0 || r.installedRouteCount.Load() == 0) {
 		r.cleanupPendingRoutesTimer.Stop()
 		r.isCleanupRoutineRunning = false
 		return true
@@ -246,23 +479,67 @@ func (r *RouteTable) maybeStopCleanupRoutineLocked() bool {
 	return false
 }
 
-func (r *RouteTable) cleanupPendingRoutes() {
+// cleanupRoutes expires pending routes and, if Config.MaxIdle is set, removes
+// installed routes that have been idle for longer than MaxIdle.
+func (r *RouteTable) cleanupRoutes() {
 	currentTime := r.config.Clock.NowMonotonic()
-	r.pendingMu.Lock()
-	defer r.pendingMu.Unlock()
+	var removed []stack.UnicastSourceAndMulticastDestination
 
-	for key, route := range r.pendingRoutes {
-		if route.isExpired(currentTime) {
-			delete(r.pendingRoutes, key)
-			route.releasePackets()
+	for i := range r.shards {
+		if r.closed.Load() {
+			// The timer fired concurrently with Close, which releases all
+			// pending packets.
+			return
 		}
+		removed = r.shards[i].cleanup(r, currentTime, removed)
 	}
 
-	if stopped := r.maybeStopCleanupRoutineLocked(); !stopped {
-		r.cleanupPendingRoutesTimer.Reset(DefaultCleanupInterval)
+	r.cleanupMu.Lock()
+	if !r.closed.Load() {
+		if stopped := r.maybeStopCleanupRoutineLocked(); !stopped {
+			r.cleanupPendingRoutesTimer.Reset(DefaultCleanupInterval)
+		}
+	}
+	r.cleanupMu.Unlock()
+
+	if r.config.OnRouteRemoved != nil {
+		for _, key := range removed {
+			r.config.OnRouteRemoved(key)
+		}
 	}
 }
 
+// cleanup expires the shard's pending routes and prunes its idle installed
+// routes as described by RouteTable.cleanupRoutes. The keys of pruned
+// installed routes are appended to removed.
+func (s *routeTableShard) cleanup(r *RouteTable, currentTime tcpip.MonotonicTime, removed []stack.UnicastSourceAndMulticastDestination) []stack.UnicastSourceAndMulticastDestination {
+	s.installedMu.Lock()
+	defer s.installedMu.Unlock()
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	if r.config.MaxIdle != 0 {
+		for key, route := range s.installedRoutes {
+			if currentTime.Sub(route.LastUsedTimestamp()) > r.config.MaxIdle {
+				delete(s.installedRoutes, key)
+				r.installedRouteCount.Add(-1)
+				removed = append(removed, key)
+			}
+		}
+	}
+
+	for key, route := range s.pendingRoutes {
+		if route.isExpired(currentTime) {
+			delete(s.pendingRoutes, key)
+			r.pendingRouteCount.Add(-1)
+			r.pendingPacketCount.Add(-int64(len(route.packets)))
+			r.dequeuePendingRoute(&route)
+			route.releasePackets()
+		}
+	}
+	return removed
+}
+
 func (r *RouteTable) newPendingRoute() PendingRoute {
 	return PendingRoute{
 		packets:    make([]stack.PacketBufferPtr, 0, r.config.MaxPendingQueueSize),
@@ -270,12 +547,106 @@ func (r *RouteTable) newPendingRoute() PendingRoute {
 	}
 }
 
+// pushQueuedPacketLocked appends p to the table's list of queued packets.
+//
+// +checklocks:r.pendingOrderMu
+func (r *RouteTable) pushQueuedPacketLocked(p *queuedPacket) {
+	p.prev = r.newestQueuedPacket
+	if r.newestQueuedPacket != nil {
+		r.newestQueuedPacket.next = p
+	} else {
+		r.oldestQueuedPacket = p
+	}
+	r.newestQueuedPacket = p
+}
+
+// removeQueuedPacketLocked removes p from the table's list of queued packets.
+//
+// +checklocks:r.pendingOrderMu
+func (r *RouteTable) removeQueuedPacketLocked(p *queuedPacket) {
+	if p.prev != nil {
+		p.prev.next = p.next
+	} else {
+		r.oldestQueuedPacket = p.next
+	}
+	if p.next != nil {
+		p.next.prev = p.prev
+	} else {
+		r.newestQueuedPacket = p.prev
+	}
+	p.prev = nil
+	p.next = nil
+}
+
+// dequeuePendingRoute removes the packets of route, which must no longer be
+// held by its shard, from the table's list of queued packets.
+func (r *RouteTable) dequeuePendingRoute(route *PendingRoute) {
+	if len(route.queued) == 0 {
+		return
+	}
+	r.pendingOrderMu.Lock()
+	defer r.pendingOrderMu.Unlock()
+	for _, p := range route.queued {
+		r.removeQueuedPacketLocked(p)
+	}
+	route.queued = nil
+}
+
+// evictPendingPackets releases the packets that were queued first across all
+// pending routes until no more than budget packets remain queued. Returns the
+// number of packets released.
+//
+// Pending routes are left in place even if their queue becomes empty, so that
+// the route's missing route event is not emitted again.
+func (r *RouteTable) evictPendingPackets(budget int64) int {
+	evicted := 0
+	for r.pendingPacketCount.Load() > budget {
+		r.pendingOrderMu.Lock()
+		oldest := r.oldestQueuedPacket
+		r.pendingOrderMu.Unlock()
+		if oldest == nil {
+			break
+		}
+		if r.shard(oldest.key).evictQueuedPacket(r, oldest) {
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// evictQueuedPacket releases p, which must be the oldest packet in the table's
+// list of queued packets. Returns false if p was concurrently dequeued.
+func (s *routeTableShard) evictQueuedPacket(r *RouteTable, p *queuedPacket) bool {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	// Packets are queued in order, so a listed packet is always the first one
+	// of its pending route.
+	route, ok := s.pendingRoutes[p.key]
+	if !ok || len(route.queued) == 0 || route.queued[0] != p {
+		return false
+	}
+
+	r.pendingOrderMu.Lock()
+	r.removeQueuedPacketLocked(p)
+	r.pendingOrderMu.Unlock()
+
+	route.packets[0].DecRef()
+	route.packets = route.packets[1:]
+	route.queued = route.queued[1:]
+	s.pendingRoutes[p.key] = route
+	r.pendingPacketCount.Add(-1)
+	return true
+}
+
 // NewInstalledRoute instantiates an installed route for the table.
 func (r *RouteTable) NewInstalledRoute(route stack.MulticastRoute) *InstalledRoute {
-	return &InstalledRoute{
-		MulticastRoute:    route,
-		lastUsedTimestamp: r.config.Clock.NowMonotonic(),
+	installedRoute := &InstalledRoute{
+		ExpectedInputInterface: route.ExpectedInputInterface,
+		lastUsedTimestamp:      r.config.Clock.NowMonotonic(),
 	}
+	installedRoute.outgoingInterfaces.Store(&route.OutgoingInterfaces)
+	return installedRoute
 }
 
 // GetRouteResult represents the result of calling GetRouteOrInsertPending.
@@ -286,6 +657,16 @@ type GetRouteResult struct {
 	// InstalledRoute represents the existing installed route. This field will
 	// only be populated if the GetRouteResultState is InstalledRouteFound.
 	InstalledRoute *InstalledRoute
+
+	// PendingQueueDepth is the number of packets queued in the pending route,
+	// including the packet that was just queued. This field will only be
+	// populated if the packet was queued in a pending route.
+	PendingQueueDepth int
+
+	// EvictedPendingPackets is the number of previously queued packets that
+	// were dropped to keep within Config.PendingPacketBudget. Callers should
+	// account for them as packets dropped due to insufficient buffer space.
+	EvictedPendingPackets int
 }
 
 // GetRouteResultState signals the result of calling GetRouteOrInsertPending.
@@ -317,52 +698,137 @@ func (e GetRouteResultState) String() string {
 	}
 }
 
+// WildcardRouteKey returns the key of the (*, G) route for the provided
+// multicast group. A (*, G) route matches packets from any unicast source that
+// do not have an exact (S, G) route installed.
+func WildcardRouteKey(group tcpip.Address) stack.UnicastSourceAndMulticastDestination {
+	return stack.UnicastSourceAndMulticastDestination{Destination: group}
+}
+
+// isWildcardRouteKey returns true if key identifies a (*, G) route.
+func isWildcardRouteKey(key stack.UnicastSourceAndMulticastDestination) bool {
+	return len(key.Source) == 0
+}
+
+// isMulticastAddress returns true if addr is an IPv4 or IPv6 multicast address.
+func isMulticastAddress(addr tcpip.Address) bool {
+	switch len(addr) {
+	case header.IPv4AddressSize:
+		return header.IsV4MulticastAddress(addr)
+	case header.IPv6AddressSize:
+		return header.IsV6MulticastAddress(addr)
+	default:
+		return false
+	}
+}
+
+// validateRouteKey returns an error if key cannot identify a multicast route,
+// i.e. if its destination is not a multicast address or its source is neither
+// a unicast address of the same family nor the wildcard source.
+func validateRouteKey(key stack.UnicastSourceAndMulticastDestination) error {
+	if !isMulticastAddress(key.Destination) {
+		return ErrNonMulticastDestination
+	}
+	if isWildcardRouteKey(key) {
+		return nil
+	}
+	if len(key.Source) != len(key.Destination) || key.Source.Unspecified() || isMulticastAddress(key.Source) || key.Source == header.IPv4Broadcast {
+		return ErrInvalidUnicastSource
+	}
+	return nil
+}
+
+// lookupInstalledRouteRLocked returns the installed route that matches key.
+//
+// An exact (S, G) match takes precedence over a (*, G) match.
+//
+// +checklocksread:s.installedMu
+func (s *routeTableShard) lookupInstalledRouteRLocked(key stack.UnicastSourceAndMulticastDestination) (*InstalledRoute, bool) {
+	if route, ok := s.installedRoutes[key]; ok {
+		return route, true
+	}
+	if isWildcardRouteKey(key) {
+		return nil, false
+	}
+	route, ok := s.installedRoutes[WildcardRouteKey(key.Destination)]
+	return route, ok
+}
+
 // GetRouteOrInsertPending attempts to fetch the installed route that matches
-// the provided key.
+// the provided key. If no exact (S, G) route is installed, then the (*, G)
+// route for the key's destination is used, if one exists.
 //
 // If no matching installed route is found, then the pkt is cloned and queued
 // in a pending route. The GetRouteResult.GetRouteResultState will indicate
 // whether the pkt was queued in a new pending route or an existing one.
 //
-// If the relevant pending route queue is at max capacity, then returns false.
-// Otherwise, returns true.
-func (r *RouteTable) GetRouteOrInsertPending(key stack.UnicastSourceAndMulticastDestination, pkt stack.PacketBufferPtr) (GetRouteResult, bool) {
-	r.installedMu.RLock()
-	defer r.installedMu.RUnlock()
+// Returns ErrNonMulticastDestination or ErrInvalidUnicastSource if the key
+// cannot identify a multicast route, ErrNoBufferSpace if the relevant pending
+// route queue is at max capacity, and ErrClosed if the table was closed.
+func (r *RouteTable) GetRouteOrInsertPending(key stack.UnicastSourceAndMulticastDestination, pkt stack.PacketBufferPtr) (GetRouteResult, error) {
+	if err := validateRouteKey(key); err != nil {
+		return GetRouteResult{}, err
+	}
 
-	if route, ok := r.installedRoutes[key]; ok {
-		return GetRouteResult{GetRouteResultState: InstalledRouteFound, InstalledRoute: route}, true
+	result, err := r.shard(key).getRouteOrInsertPending(r, key, pkt)
+	if err != nil {
+		return GetRouteResult{}, err
 	}
 
-	r.pendingMu.Lock()
-	defer r.pendingMu.Unlock()
+	// Evict packets only after the shard's locks are released, as the oldest
+	// packets may be queued in any shard.
+	if budget := r.config.PendingPacketBudget; budget != 0 && result.GetRouteResultState != InstalledRouteFound && r.pendingPacketCount.Load() > int64(budget) {
+		result.EvictedPendingPackets = r.evictPendingPackets(int64(budget))
+	}
+	return result, nil
+}
+
+// getRouteOrInsertPending implements RouteTable.GetRouteOrInsertPending for
+// the shard holding key, except for enforcing Config.PendingPacketBudget.
+func (s *routeTableShard) getRouteOrInsertPending(r *RouteTable, key stack.UnicastSourceAndMulticastDestination, pkt stack.PacketBufferPtr) (GetRouteResult, error) {
+	s.installedMu.RLock()
+	defer s.installedMu.RUnlock()
+
+	if route, ok := s.lookupInstalledRouteRLocked(key); ok {
+		return GetRouteResult{GetRouteResultState: InstalledRouteFound, InstalledRoute: route}, nil
+	}
+
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	if r.closed.Load() {
+		return GetRouteResult{}, ErrClosed
+	}
 
-	pendingRoute, getRouteResultState := r.getOrCreatePendingRouteRLocked(key)
+	pendingRoute, getRouteResultState := s.getOrCreatePendingRouteRLocked(r, key)
 	if len(pendingRoute.packets) >= int(r.config.MaxPendingQueueSize) {
 		// The incoming packet is rejected if the pending queue is already at max
 		// capacity. This behavior matches the Linux implementation:
 		// https://github.com/torvalds/linux/blob/ae085d7f936/net/ipv4/ipmr.c#L1147
-		return GetRouteResult{}, false
+		return GetRouteResult{}, ErrNoBufferSpace
 	}
-	pendingRoute.packets = append(pendingRoute.packets, pkt.Clone())
-	r.pendingRoutes[key] = pendingRoute
 
-	if !r.isCleanupRoutineRunning {
-		// The cleanup routine isn't running, but should be. Start it.
-		if r.cleanupPendingRoutesTimer == nil {
-			r.cleanupPendingRoutesTimer = r.config.Clock.AfterFunc(DefaultCleanupInterval, r.cleanupPendingRoutes)
-		} else {
-			r.cleanupPendingRoutesTimer.Reset(DefaultCleanupInterval)
-		}
-		r.isCleanupRoutineRunning = true
+	pendingRoute.packets = append(pendingRoute.packets, pkt.Clone())
+	if r.config.PendingPacketBudget != 0 {
+		p := &queuedPacket{key: key}
+		r.pendingOrderMu.Lock()
+		r.pushQueuedPacketLocked(p)
+		r.pendingOrderMu.Unlock()
+		pendingRoute.queued = append(pendingRoute.queued, p)
 	}
+	r.pendingPacketCount.Add(1)
+	if getRouteResultState == NoRouteFoundAndPendingInserted {
+		r.pendingRouteCount.Add(1)
+	}
+	s.pendingRoutes[key] = pendingRoute
+	r.startCleanupRoutine()
 
-	return GetRouteResult{GetRouteResultState: getRouteResultState, InstalledRoute: nil}, true
+	return GetRouteResult{GetRouteResultState: getRouteResultState, InstalledRoute: nil, PendingQueueDepth: len(pendingRoute.packets)}, nil
 }
 
-// +checklocks:r.pendingMu
-func (r *RouteTable) getOrCreatePendingRouteRLocked(key stack.UnicastSourceAndMulticastDestination) (PendingRoute, GetRouteResultState) {
-	if pendingRoute, ok := r.pendingRoutes[key]; ok {
+// +checklocks:s.pendingMu
+func (s *routeTableShard) getOrCreatePendingRouteRLocked(r *RouteTable, key stack.UnicastSourceAndMulticastDestination) (PendingRoute, GetRouteResultState) {
+	if pendingRoute, ok := s.pendingRoutes[key]; ok {
 		return pendingRoute, PacketQueuedInPendingRoute
 	}
 	return r.newPendingRoute(), NoRouteFoundAndPendingInserted
@@ -374,18 +840,43 @@ func (r *RouteTable) getOrCreatePendingRouteRLocked(key stack.UnicastSourceAndMu
 // returned. The caller assumes ownership of these packets and is responsible
 // for forwarding and releasing them. If an installed route already exists for
 // the provided key, then it is overwritten.
+//
+// A (*, G) route can be installed by using the key returned by
+// WildcardRouteKey. Only packets pending on that exact key are released; those
+// pending on an (S, G) key remain queued until their own route is installed or
+// they expire.
 func (r *RouteTable) AddInstalledRoute(key stack.UnicastSourceAndMulticastDestination, route *InstalledRoute) []stack.PacketBufferPtr {
-	r.installedMu.Lock()
-	defer r.installedMu.Unlock()
-	r.installedRoutes[key] = route
-
-	r.pendingMu.Lock()
-	pendingRoute, ok := r.pendingRoutes[key]
-	delete(r.pendingRoutes, key)
-	// No need to reset the timer here. The cleanup routine is responsible for
-	// doing so.
-	_ = r.maybeStopCleanupRoutineLocked()
-	r.pendingMu.Unlock()
+	shard := r.shard(key)
+	shard.installedMu.Lock()
+	if _, ok := shard.installedRoutes[key]; !ok {
+		r.installedRouteCount.Add(1)
+	}
+	shard.installedRoutes[key] = route
+
+	shard.pendingMu.Lock()
+	pendingRoute, ok := shard.pendingRoutes[key]
+	if ok {
+		delete(shard.pendingRoutes, key)
+		r.pendingRouteCount.Add(-1)
+		r.pendingPacketCount.Add(-int64(len(pendingRoute.packets)))
+		r.dequeuePendingRoute(&pendingRoute)
+	}
+	r.cleanupMu.Lock()
+	if r.config.MaxIdle != 0 {
+		// The route must be pruned once it becomes idle.
+		r.startCleanupRoutineLocked()
+	} else {
+		// No need to reset the timer here. The cleanup routine is responsible
+		// for doing so.
+		_ = r.maybeStopCleanupRoutineLocked()
+	}
+	r.cleanupMu.Unlock()
+	shard.pendingMu.Unlock()
+	shard.installedMu.Unlock()
+
+	if r.config.OnRouteInstalled != nil {
+		r.config.OnRouteInstalled(key)
+	}
 
 	// Ignore the pending route if it is expired. It may be in this state since
 	// the cleanup process is only run periodically.
@@ -397,41 +888,96 @@ func (r *RouteTable) AddInstalledRoute(key stack.UnicastSourceAndMulticastDestin
 	return pendingRoute.packets
 }
 
+// UpdateInstalledRoute replaces the outgoing interfaces of the installed route
+// that matches the provided key.
+//
+// The route is updated in place, so callers still holding the route observe
+// the new interfaces and its last used timestamp and stats are preserved.
+//
+// Returns true if a matching route was found. Otherwise returns false.
+func (r *RouteTable) UpdateInstalledRoute(key stack.UnicastSourceAndMulticastDestination, outgoingInterfaces []stack.MulticastRouteOutgoingInterface) bool {
+	shard := r.shard(key)
+	shard.installedMu.RLock()
+	defer shard.installedMu.RUnlock()
+
+	route, ok := shard.installedRoutes[key]
+	if !ok {
+		return false
+	}
+
+	route.outgoingInterfaces.Store(&outgoingInterfaces)
+	return true
+}
+
 // RemoveInstalledRoute deletes any installed route that matches the provided
 // key.
 //
 // Returns true if a route was removed. Otherwise returns false.
 func (r *RouteTable) RemoveInstalledRoute(key stack.UnicastSourceAndMulticastDestination) bool {
-	r.installedMu.Lock()
-	defer r.installedMu.Unlock()
-
-	if _, ok := r.installedRoutes[key]; ok {
-		delete(r.installedRoutes, key)
-		return true
+	shard := r.shard(key)
+	shard.installedMu.Lock()
+	_, ok := shard.installedRoutes[key]
+	if ok {
+		delete(shard.installedRoutes, key)
+		r.installedRouteCount.Add(-1)
 	}
+	shard.installedMu.Unlock()
 
-	return false
+	if ok && r.config.OnRouteRemoved != nil {
+		r.config.OnRouteRemoved(key)
+	}
+	return ok
 }
 
 // RemoveAllInstalledRoutes removes all installed routes from the table.
 func (r *RouteTable) RemoveAllInstalledRoutes() {
-	r.installedMu.Lock()
-	defer r.installedMu.Unlock()
+	var removed []stack.UnicastSourceAndMulticastDestination
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.installedMu.Lock()
+		for key := range shard.installedRoutes {
+			delete(shard.installedRoutes, key)
+			r.installedRouteCount.Add(-1)
+			if r.config.OnRouteRemoved != nil {
+				removed = append(removed, key)
+			}
+		}
+		shard.installedMu.Unlock()
+	}
 
-	for key := range r.installedRoutes {
-		delete(r.installedRoutes, key)
+	for _, key := range removed {
+		r.config.OnRouteRemoved(key)
 	}
 }
 
+// GetInstalledRoute returns the installed route that exactly matches the
+// provided key, without falling back to a (*, G) route.
+//
+// Unlike forwarding, fetching a route does not update its last used
+// timestamp, so control plane queries do not keep idle routes from being
+// pruned. The returned route is shared with the table and must not be
+// modified.
+//
+// Returns true if a matching route was found. Otherwise returns false.
+func (r *RouteTable) GetInstalledRoute(key stack.UnicastSourceAndMulticastDestination) (*InstalledRoute, bool) {
+	shard := r.shard(key)
+	shard.installedMu.RLock()
+	defer shard.installedMu.RUnlock()
+
+	route, ok := shard.installedRoutes[key]
+	return route, ok
+}
+
 // GetLastUsedTimestamp returns a monotonic timestamp that represents the last
 // time the route that matches the provided key was used or updated.
 //
 // Returns true if a matching route was found. Otherwise returns false.
 func (r *RouteTable) GetLastUsedTimestamp(key stack.UnicastSourceAndMulticastDestination) (tcpip.MonotonicTime, bool) {
-	r.installedMu.RLock()
-	defer r.installedMu.RUnlock()
+	shard := r.shard(key)
+	shard.installedMu.RLock()
+	defer shard.installedMu.RUnlock()
 
-	if route, ok := r.installedRoutes[key]; ok {
+	if route, ok := shard.installedRoutes[key]; ok {
 		return route.LastUsedTimestamp(), true
 	}
 	return tcpip.MonotonicTime{}, false
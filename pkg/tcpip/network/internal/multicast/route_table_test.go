@@ -16,6 +16,7 @@ package multicast
 
 import (
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -39,7 +40,8 @@ const (
 
 var (
 	defaultAddress            = testutil.MustParse4("192.168.1.1")
-	defaultRouteKey           = stack.UnicastSourceAndMulticastDestination{Source: defaultAddress, Destination: defaultAddress}
+	defaultMulticastAddress   = testutil.MustParse4("224.0.1.1")
+	defaultRouteKey           = stack.UnicastSourceAndMulticastDestination{Source: defaultAddress, Destination: defaultMulticastAddress}
 	defaultOutgoingInterfaces = []stack.MulticastRouteOutgoingInterface{{ID: outgoingNICID, MinTTL: defaultMinTTL}}
 	defaultRoute              = stack.MulticastRoute{inputNICID, defaultOutgoingInterfaces}
 )
@@ -64,6 +66,25 @@ func withClock(clock tcpip.Clock) configOption {
 	}
 }
 
+func withRouteCallbacks(onInstalled, onRemoved func(stack.UnicastSourceAndMulticastDestination)) configOption {
+	return func(c *Config) {
+		c.OnRouteInstalled = onInstalled
+		c.OnRouteRemoved = onRemoved
+	}
+}
+
+func withMaxIdle(maxIdle time.Duration) configOption {
+	return func(c *Config) {
+		c.MaxIdle = maxIdle
+	}
+}
+
+func withPendingPacketBudget(budget int) configOption {
+	return func(c *Config) {
+		c.PendingPacketBudget = budget
+	}
+}
+
 func defaultConfig(opts ...configOption) Config {
 	c := &Config{
 		MaxPendingQueueSize: DefaultMaxPendingQueueSize,
@@ -77,8 +98,17 @@ func defaultConfig(opts ...configOption) Config {
 	return *c
 }
 
+func newInstalledRoute(route stack.MulticastRoute, lastUsedTimestamp tcpip.MonotonicTime) *InstalledRoute {
+	installedRoute := &InstalledRoute{
+		ExpectedInputInterface: route.ExpectedInputInterface,
+		lastUsedTimestamp:      lastUsedTimestamp,
+	}
+	installedRoute.outgoingInterfaces.Store(&route.OutgoingInterfaces)
+	return installedRoute
+}
+
 func installedRouteComparer(a *InstalledRoute, b *InstalledRoute) bool {
-	if !cmp.Equal(a.OutgoingInterfaces, b.OutgoingInterfaces) {
+	if !cmp.Equal(a.OutgoingInterfaces(), b.OutgoingInterfaces()) {
 		return false
 	}
 
@@ -146,16 +176,41 @@ func TestNewInstalledRoute(t *testing.T) {
 
 	route := table.NewInstalledRoute(defaultRoute)
 
-	expectedRoute := &InstalledRoute{
-		MulticastRoute:    defaultRoute,
-		lastUsedTimestamp: clock.NowMonotonic(),
-	}
+	expectedRoute := newInstalledRoute(defaultRoute, clock.NowMonotonic())
 
 	if diff := cmp.Diff(expectedRoute, route, cmp.Comparer(installedRouteComparer)); diff != "" {
 		t.Errorf("Installed route mismatch (-want +got):\n%s", diff)
 	}
 }
 
+func TestLastUsedTimestampWithClockSteppingBackwards(t *testing.T) {
+	table := RouteTable{}
+	defer table.Close()
+	clock := faketime.NewManualClock()
+	clock.Advance(10 * time.Second)
+	config := defaultConfig(withClock(clock))
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	route := table.NewInstalledRoute(defaultRoute)
+	table.AddInstalledRoute(defaultRouteKey, route)
+	wantTimestamp := route.LastUsedTimestamp()
+
+	clock.Advance(-5 * time.Second)
+
+	// A route must not look less recently used than it was after the clock
+	// stepped backwards.
+	route.SetLastUsedTimestamp(clock.NowMonotonic())
+	timestamp, found := table.GetLastUsedTimestamp(defaultRouteKey)
+	if !found {
+		t.Fatalf("table.GetLastUsedTimestamp(%#v) = (_, false), want = (_, true)", defaultRouteKey)
+	}
+	if timestamp != wantTimestamp {
+		t.Errorf("table.GetLastUsedTimestamp(%#v) = (%s, _), want = (%s, _)", defaultRouteKey, timestamp, wantTimestamp)
+	}
+}
+
 func TestGetRouteResultStates(t *testing.T) {
 	table := RouteTable{}
 	defer table.Close()
@@ -168,15 +223,16 @@ func TestGetRouteResultStates(t *testing.T) {
 	defer pkt.DecRef()
 	// Queue two pending packets for the same route. The GetRouteResultState
 	// should transition from NoRouteFoundAndPendingInserted to
-	// PacketQueuedInPendingRoute.
-	for _, wantPendingRouteState := range []GetRouteResultState{NoRouteFoundAndPendingInserted, PacketQueuedInPendingRoute} {
-		routeResult, hasBufferSpace := table.GetRouteOrInsertPending(defaultRouteKey, pkt)
+	// PacketQueuedInPendingRoute and the queue depth should grow with each
+	// packet.
+	for i, wantPendingRouteState := range []GetRouteResultState{NoRouteFoundAndPendingInserted, PacketQueuedInPendingRoute} {
+		routeResult, err := table.GetRouteOrInsertPending(defaultRouteKey, pkt)
 
-		if !hasBufferSpace {
-			t.Errorf("table.GetRouteOrInsertPending(%#v, %#v) = (_, false), want = (_, true)", defaultRouteKey, pkt)
+		if err != nil {
+			t.Errorf("table.GetRouteOrInsertPending(%#v, %#v): %s", defaultRouteKey, pkt, err)
 		}
 
-		expectedResult := GetRouteResult{GetRouteResultState: wantPendingRouteState}
+		expectedResult := GetRouteResult{GetRouteResultState: wantPendingRouteState, PendingQueueDepth: i + 1}
 		if diff := cmp.Diff(expectedResult, routeResult); diff != "" {
 			t.Errorf("table.GetRouteOrInsertPending(%#v, %#v) GetRouteResult mismatch (-want +got):\n%s", defaultRouteKey, pkt, diff)
 		}
@@ -184,8 +240,59 @@ func TestGetRouteResultStates(t *testing.T) {
 
 	// Queuing a third packet should yield an error since the pending queue is
 	// already at max capacity.
-	if _, hasBufferSpace := table.GetRouteOrInsertPending(defaultRouteKey, pkt); hasBufferSpace {
-		t.Errorf("table.GetRouteOrInsertPending(%#v, %#v) = (_, true), want = (_, false)", defaultRouteKey, pkt)
+	if _, err := table.GetRouteOrInsertPending(defaultRouteKey, pkt); err != ErrNoBufferSpace {
+		t.Errorf("got table.GetRouteOrInsertPending(%#v, %#v) = (_, %v), want = (_, %s)", defaultRouteKey, pkt, err, ErrNoBufferSpace)
+	}
+}
+
+func TestGetRouteOrInsertPendingRejectsInvalidKeys(t *testing.T) {
+	testCases := []struct {
+		name    string
+		key     stack.UnicastSourceAndMulticastDestination
+		wantErr error
+	}{
+		{
+			name:    "unicast destination",
+			key:     stack.UnicastSourceAndMulticastDestination{Source: defaultAddress, Destination: testutil.MustParse4("192.168.1.2")},
+			wantErr: ErrNonMulticastDestination,
+		},
+		{
+			name:    "unspecified source",
+			key:     stack.UnicastSourceAndMulticastDestination{Source: testutil.MustParse4("0.0.0.0"), Destination: defaultMulticastAddress},
+			wantErr: ErrInvalidUnicastSource,
+		},
+		{
+			name:    "multicast source",
+			key:     stack.UnicastSourceAndMulticastDestination{Source: defaultMulticastAddress, Destination: defaultMulticastAddress},
+			wantErr: ErrInvalidUnicastSource,
+		},
+		{
+			name:    "mismatched families",
+			key:     stack.UnicastSourceAndMulticastDestination{Source: testutil.MustParse6("2001:db8::1"), Destination: defaultMulticastAddress},
+			wantErr: ErrInvalidUnicastSource,
+		},
+		{
+			name:    "wildcard source",
+			key:     WildcardRouteKey(defaultMulticastAddress),
+			wantErr: nil,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			table := RouteTable{}
+			defer table.Close()
+			config := defaultConfig()
+			if err := table.Init(config); err != nil {
+				t.Fatalf("table.Init(%#v): %s", config, err)
+			}
+
+			pkt := newPacketBuffer("hello")
+			defer pkt.DecRef()
+			if _, err := table.GetRouteOrInsertPending(test.key, pkt); err != test.wantErr {
+				t.Errorf("got table.GetRouteOrInsertPending(%#v, _) = (_, %v), want = (_, %v)", test.key, err, test.wantErr)
+			}
+		})
 	}
 }
 
@@ -231,19 +338,22 @@ func TestPendingRouteExpiration(t *testing.T) {
 
 			clock.Advance(test.advanceBeforeInsert)
 
-			if _, hasBufferSpace := table.GetRouteOrInsertPending(defaultRouteKey, pkt); !hasBufferSpace {
-				t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): false", defaultRouteKey, pkt)
+			if _, err := table.GetRouteOrInsertPending(defaultRouteKey, pkt); err != nil {
+				t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): %s", defaultRouteKey, pkt, err)
 			}
 
 			clock.Advance(test.advanceAfterInsert)
 
-			table.pendingMu.RLock()
-			_, ok := table.pendingRoutes[defaultRouteKey]
+			shard := table.shard(defaultRouteKey)
+			shard.pendingMu.RLock()
+			_, ok := shard.pendingRoutes[defaultRouteKey]
+			shard.pendingMu.RUnlock()
 
+			table.cleanupMu.Lock()
 			if table.isCleanupRoutineRunning != test.wantPendingRoute {
 				t.Errorf("table.isCleanupRoutineRunning = %t, want = %t", table.isCleanupRoutineRunning, test.wantPendingRoute)
 			}
-			table.pendingMu.RUnlock()
+			table.cleanupMu.Unlock()
 
 			if test.wantPendingRoute != ok {
 				t.Errorf("table.pendingRoutes[%#v] = (_, %t), want = (_, %t)", defaultRouteKey, ok, test.wantPendingRoute)
@@ -252,6 +362,50 @@ func TestPendingRouteExpiration(t *testing.T) {
 	}
 }
 
+func TestPendingPacketBudgetEvictsOldestPacket(t *testing.T) {
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig(withMaxPendingQueueSize(3), withPendingPacketBudget(2))
+
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	keys := []stack.UnicastSourceAndMulticastDestination{
+		{Source: testutil.MustParse4("192.168.1.1"), Destination: defaultMulticastAddress},
+		{Source: testutil.MustParse4("192.168.1.2"), Destination: defaultMulticastAddress},
+		{Source: testutil.MustParse4("192.168.1.3"), Destination: defaultMulticastAddress},
+	}
+	// No key reaches the per-route queue limit, but the third packet exceeds
+	// the table-wide budget.
+	wantEvicted := []int{0, 0, 1}
+
+	for i, key := range keys {
+		pkt := newPacketBuffer("foo")
+		result, err := table.GetRouteOrInsertPending(key, pkt)
+		pkt.DecRef()
+		if err != nil {
+			t.Fatalf("table.GetRouteOrInsertPending(%#v, _): %s", key, err)
+		}
+		if got, want := result.EvictedPendingPackets, wantEvicted[i]; got != want {
+			t.Errorf("table.GetRouteOrInsertPending(%#v, _).EvictedPendingPackets = %d, want = %d", key, got, want)
+		}
+	}
+
+	// The packet queued first, for the first key, should have been evicted.
+	wantPending := []int{0, 1, 1}
+	for i, key := range keys {
+		route := table.NewInstalledRoute(defaultRoute)
+		pendingPackets := table.AddInstalledRoute(key, route)
+		if got, want := len(pendingPackets), wantPending[i]; got != want {
+			t.Errorf("got len(table.AddInstalledRoute(%#v, _)) = %d, want = %d", key, got, want)
+		}
+		for _, pendingPkt := range pendingPackets {
+			pendingPkt.DecRef()
+		}
+	}
+}
+
 func TestAddInstalledRouteWithPending(t *testing.T) {
 	pkt := newPacketBuffer("foo")
 	defer pkt.DecRef()
@@ -294,12 +448,14 @@ func TestAddInstalledRouteWithPending(t *testing.T) {
 				t.Fatalf("table.Init(%#v): %s", config, err)
 			}
 
-			if _, hasBufferSpace := table.GetRouteOrInsertPending(defaultRouteKey, pkt); !hasBufferSpace {
-				t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): false", defaultRouteKey, pkt)
+			if _, err := table.GetRouteOrInsertPending(defaultRouteKey, pkt); err != nil {
+				t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): %s", defaultRouteKey, pkt, err)
 			}
 
 			// Disable the cleanup routine.
+			table.cleanupMu.Lock()
 			table.cleanupPendingRoutesTimer.Stop()
+			table.cleanupMu.Unlock()
 
 			clock.Advance(test.advance)
 
@@ -315,11 +471,12 @@ func TestAddInstalledRouteWithPending(t *testing.T) {
 			}
 
 			// Verify that the pending route is actually deleted.
-			table.pendingMu.RLock()
-			if pendingRoute, ok := table.pendingRoutes[defaultRouteKey]; ok {
-				t.Errorf("table.pendingRoutes[%#v] = (%#v, true), want (_, false)", defaultRouteKey, pendingRoute)
+			shard := table.shard(defaultRouteKey)
+			shard.pendingMu.RLock()
+			if pendingRoute, ok := shard.pendingRoutes[defaultRouteKey]; ok {
+				t.Errorf("shard.pendingRoutes[%#v] = (%#v, true), want (_, false)", defaultRouteKey, pendingRoute)
 			}
-			table.pendingMu.RUnlock()
+			shard.pendingMu.RUnlock()
 		})
 	}
 }
@@ -347,10 +504,10 @@ func TestAddInstalledRouteWithNoPending(t *testing.T) {
 		// AddInstalledRoute is invoked for the same routeKey two times. Verify
 		// that the fetched InstalledRoute reflects the most recent invocation of
 		// AddInstalledRoute.
-		routeResult, hasBufferSpace := table.GetRouteOrInsertPending(defaultRouteKey, pkt)
+		routeResult, err := table.GetRouteOrInsertPending(defaultRouteKey, pkt)
 
-		if !hasBufferSpace {
-			t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): false", defaultRouteKey, pkt)
+		if err != nil {
+			t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): %s", defaultRouteKey, pkt, err)
 		}
 
 		if routeResult.GetRouteResultState != InstalledRouteFound {
@@ -363,6 +520,165 @@ func TestAddInstalledRouteWithNoPending(t *testing.T) {
 	}
 }
 
+func TestWildcardSourceRoute(t *testing.T) {
+	otherSourceRouteKey := stack.UnicastSourceAndMulticastDestination{Source: testutil.MustParse4("192.168.1.2"), Destination: defaultMulticastAddress}
+	wildcardRouteKey := WildcardRouteKey(defaultMulticastAddress)
+
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig()
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	exactRoute := table.NewInstalledRoute(defaultRoute)
+	wildcardRoute := table.NewInstalledRoute(stack.MulticastRoute{defaultNICID, defaultOutgoingInterfaces})
+	table.AddInstalledRoute(defaultRouteKey, exactRoute)
+	table.AddInstalledRoute(wildcardRouteKey, wildcardRoute)
+
+	pkt := newPacketBuffer("hello")
+	defer pkt.DecRef()
+
+	testCases := []struct {
+		name      string
+		key       stack.UnicastSourceAndMulticastDestination
+		wantRoute *InstalledRoute
+	}{
+		{
+			name:      "exact match preferred",
+			key:       defaultRouteKey,
+			wantRoute: exactRoute,
+		},
+		{
+			name:      "unmatched source uses wildcard",
+			key:       otherSourceRouteKey,
+			wantRoute: wildcardRoute,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			routeResult, err := table.GetRouteOrInsertPending(test.key, pkt)
+			if err != nil {
+				t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): %s", test.key, pkt, err)
+			}
+
+			if routeResult.GetRouteResultState != InstalledRouteFound {
+				t.Errorf("routeResult.GetRouteResultState = %s, want = InstalledRouteFound", routeResult.GetRouteResultState)
+			}
+
+			if diff := cmp.Diff(test.wantRoute, routeResult.InstalledRoute, cmp.Comparer(installedRouteComparer)); diff != "" {
+				t.Errorf("routeResult.InstalledRoute mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdateInstalledRoute(t *testing.T) {
+	clock := faketime.NewManualClock()
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig(withClock(clock))
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	route := table.NewInstalledRoute(defaultRoute)
+	table.AddInstalledRoute(defaultRouteKey, route)
+	lastUsedTime := clock.NowMonotonic().Add(5 * time.Second)
+	route.SetLastUsedTimestamp(lastUsedTime)
+	clock.Advance(10 * time.Second)
+
+	newOutgoingInterfaces := []stack.MulticastRouteOutgoingInterface{
+		{ID: outgoingNICID, MinTTL: defaultMinTTL},
+		{ID: defaultNICID, MinTTL: defaultMinTTL},
+	}
+	if updated := table.UpdateInstalledRoute(defaultRouteKey, newOutgoingInterfaces); !updated {
+		t.Fatalf("table.UpdateInstalledRoute(%#v, %#v) = false, want = true", defaultRouteKey, newOutgoingInterfaces)
+	}
+
+	pkt := newPacketBuffer("hello")
+	defer pkt.DecRef()
+	result, err := table.GetRouteOrInsertPending(defaultRouteKey, pkt)
+	if err != nil {
+		t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): %s", defaultRouteKey, pkt, err)
+	}
+	if result.GetRouteResultState != InstalledRouteFound {
+		t.Fatalf("result.GetRouteResultState = %s, want = InstalledRouteFound", result.GetRouteResultState)
+	}
+
+	if result.InstalledRoute != route {
+		t.Errorf("got result.InstalledRoute = %p, want = %p", result.InstalledRoute, route)
+	}
+	want := newInstalledRoute(stack.MulticastRoute{inputNICID, newOutgoingInterfaces}, lastUsedTime)
+	if diff := cmp.Diff(want, result.InstalledRoute, cmp.Comparer(installedRouteComparer)); diff != "" {
+		t.Errorf("result.InstalledRoute mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUpdateInstalledRouteWithNoMatchingRoute(t *testing.T) {
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig()
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	if updated := table.UpdateInstalledRoute(defaultRouteKey, defaultOutgoingInterfaces); updated {
+		t.Errorf("table.UpdateInstalledRoute(%#v, %#v) = true, want = false", defaultRouteKey, defaultOutgoingInterfaces)
+	}
+}
+
+func TestCheckMinTTL(t *testing.T) {
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig()
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	lowTTLInterface := stack.MulticastRouteOutgoingInterface{ID: outgoingNICID, MinTTL: defaultMinTTL}
+	highTTLInterface := stack.MulticastRouteOutgoingInterface{ID: defaultNICID, MinTTL: 2 * defaultMinTTL}
+	route := table.NewInstalledRoute(stack.MulticastRoute{
+		ExpectedInputInterface: inputNICID,
+		OutgoingInterfaces:     []stack.MulticastRouteOutgoingInterface{lowTTLInterface, highTTLInterface},
+	})
+
+	for _, ttl := range []uint8{0, defaultMinTTL - 1, defaultMinTTL, 2*defaultMinTTL - 1, 2 * defaultMinTTL} {
+		for _, outgoingInterface := range route.OutgoingInterfaces() {
+			want := ttl >= outgoingInterface.MinTTL
+			if got := route.CheckMinTTL(outgoingInterface, ttl); got != want {
+				t.Errorf("route.CheckMinTTL(%#v, %d) = %t, want = %t", outgoingInterface, ttl, got, want)
+			}
+		}
+	}
+
+	want := InstalledRouteStats{
+		DroppedBelowMinTTL: map[tcpip.NICID]uint64{
+			outgoingNICID: 2,
+			defaultNICID:  4,
+		},
+	}
+	if diff := cmp.Diff(want, route.Stats()); diff != "" {
+		t.Errorf("route.Stats() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Stats are preserved when the outgoing interfaces are updated.
+	table.AddInstalledRoute(defaultRouteKey, route)
+	if updated := table.UpdateInstalledRoute(defaultRouteKey, defaultOutgoingInterfaces); !updated {
+		t.Fatalf("table.UpdateInstalledRoute(%#v, %#v) = false, want = true", defaultRouteKey, defaultOutgoingInterfaces)
+	}
+	pkt := newPacketBuffer("hello")
+	defer pkt.DecRef()
+	result, _ := table.GetRouteOrInsertPending(defaultRouteKey, pkt)
+	if result.InstalledRoute == nil {
+		t.Fatalf("got table.GetRouteOrInsertPending(%#v, _) = (%#v, _), want an installed route", defaultRouteKey, result)
+	}
+	if diff := cmp.Diff(want, result.InstalledRoute.Stats()); diff != "" {
+		t.Errorf("updated route stats mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestRemoveInstalledRoute(t *testing.T) {
 	table := RouteTable{}
 	defer table.Close()
@@ -382,10 +698,10 @@ func TestRemoveInstalledRoute(t *testing.T) {
 	pkt := newPacketBuffer("hello")
 	defer pkt.DecRef()
 
-	result, hasBufferSpace := table.GetRouteOrInsertPending(defaultRouteKey, pkt)
+	result, err := table.GetRouteOrInsertPending(defaultRouteKey, pkt)
 
-	if !hasBufferSpace {
-		t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): false", defaultRouteKey, pkt)
+	if err != nil {
+		t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): %s", defaultRouteKey, pkt, err)
 	}
 
 	if result.InstalledRoute != nil {
@@ -418,7 +734,7 @@ func TestRemoveAllInstalledRoutes(t *testing.T) {
 
 	routes := map[stack.UnicastSourceAndMulticastDestination]stack.MulticastRoute{
 		defaultRouteKey: defaultRoute,
-		stack.UnicastSourceAndMulticastDestination{otherAddress, otherAddress}: defaultRoute,
+		stack.UnicastSourceAndMulticastDestination{otherAddress, defaultMulticastAddress}: defaultRoute,
 	}
 
 	for key, route := range routes {
@@ -432,10 +748,10 @@ func TestRemoveAllInstalledRoutes(t *testing.T) {
 		pkt := newPacketBuffer("hello")
 		defer pkt.DecRef()
 
-		result, hasBufferSpace := table.GetRouteOrInsertPending(key, pkt)
+		result, err := table.GetRouteOrInsertPending(key, pkt)
 
-		if !hasBufferSpace {
-			t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): false", key, pkt)
+		if err != nil {
+			t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): %s", key, pkt, err)
 		}
 
 		if result.InstalledRoute != nil {
@@ -444,6 +760,131 @@ func TestRemoveAllInstalledRoutes(t *testing.T) {
 	}
 }
 
+func TestRouteCallbacks(t *testing.T) {
+	var installed, removed []stack.UnicastSourceAndMulticastDestination
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig(withRouteCallbacks(
+		func(key stack.UnicastSourceAndMulticastDestination) {
+			installed = append(installed, key)
+		},
+		func(key stack.UnicastSourceAndMulticastDestination) {
+			removed = append(removed, key)
+		},
+	))
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	wantKeys := []stack.UnicastSourceAndMulticastDestination{defaultRouteKey}
+
+	table.AddInstalledRoute(defaultRouteKey, table.NewInstalledRoute(defaultRoute))
+	if diff := cmp.Diff(wantKeys, installed); diff != "" {
+		t.Errorf("installed routes mismatch (-want +got):\n%s", diff)
+	}
+	if len(removed) != 0 {
+		t.Errorf("got removed routes = %#v, want = []", removed)
+	}
+
+	if !table.RemoveInstalledRoute(defaultRouteKey) {
+		t.Fatalf("table.RemoveInstalledRoute(%#v) = false, want = true", defaultRouteKey)
+	}
+	// Removing a route that no longer exists must not invoke the callback.
+	if table.RemoveInstalledRoute(defaultRouteKey) {
+		t.Fatalf("table.RemoveInstalledRoute(%#v) = true, want = false", defaultRouteKey)
+	}
+	if diff := cmp.Diff(wantKeys, removed); diff != "" {
+		t.Errorf("removed routes mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(wantKeys, installed); diff != "" {
+		t.Errorf("installed routes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPruneIdleInstalledRoutes(t *testing.T) {
+	const maxIdle = 2 * DefaultCleanupInterval
+	activeRouteKey := stack.UnicastSourceAndMulticastDestination{Source: testutil.MustParse4("192.168.1.2"), Destination: defaultMulticastAddress}
+
+	var removed []stack.UnicastSourceAndMulticastDestination
+	clock := faketime.NewManualClock()
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig(
+		withClock(clock),
+		withMaxIdle(maxIdle),
+		withRouteCallbacks(nil, func(key stack.UnicastSourceAndMulticastDestination) {
+			removed = append(removed, key)
+		}),
+	)
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	table.AddInstalledRoute(defaultRouteKey, table.NewInstalledRoute(defaultRoute))
+	activeRoute := table.NewInstalledRoute(defaultRoute)
+	table.AddInstalledRoute(activeRouteKey, activeRoute)
+
+	// Keep one route in use while the other one idles past maxIdle.
+	for elapsed := time.Duration(0); elapsed <= maxIdle; elapsed += DefaultCleanupInterval {
+		clock.Advance(DefaultCleanupInterval)
+		activeRoute.SetLastUsedTimestamp(clock.NowMonotonic())
+	}
+
+	if _, found := table.GetLastUsedTimestamp(defaultRouteKey); found {
+		t.Errorf("table.GetLastUsedTimestamp(%#v) = (_, true) for an idle route, want = (_, false)", defaultRouteKey)
+	}
+	if _, found := table.GetLastUsedTimestamp(activeRouteKey); !found {
+		t.Errorf("table.GetLastUsedTimestamp(%#v) = (_, false) for an active route, want = (_, true)", activeRouteKey)
+	}
+	if diff := cmp.Diff([]stack.UnicastSourceAndMulticastDestination{defaultRouteKey}, removed); diff != "" {
+		t.Errorf("removed routes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetInstalledRoute(t *testing.T) {
+	clock := faketime.NewManualClock()
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig(withClock(clock))
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	if _, ok := table.GetInstalledRoute(defaultRouteKey); ok {
+		t.Fatalf("table.GetInstalledRoute(%#v) = (_, true) before install, want = (_, false)", defaultRouteKey)
+	}
+
+	installedTime := clock.NowMonotonic()
+	table.AddInstalledRoute(defaultRouteKey, table.NewInstalledRoute(defaultRoute))
+	clock.Advance(time.Second)
+
+	route, ok := table.GetInstalledRoute(defaultRouteKey)
+	if !ok {
+		t.Fatalf("table.GetInstalledRoute(%#v) = (_, false), want = (_, true)", defaultRouteKey)
+	}
+	if diff := cmp.Diff(defaultRoute.OutgoingInterfaces, route.OutgoingInterfaces()); diff != "" {
+		t.Errorf("table.GetInstalledRoute(%#v) route mismatch (-want +got):\n%s", defaultRouteKey, diff)
+	}
+	if got := route.LastUsedTimestamp(); got != installedTime {
+		t.Errorf("got route.LastUsedTimestamp() = %s after table.GetInstalledRoute, want = %s", got, installedTime)
+	}
+
+	// Forwarding a packet is what advances the timestamp.
+	forwardTime := clock.NowMonotonic()
+	route.SetLastUsedTimestamp(forwardTime)
+	if got, ok := table.GetLastUsedTimestamp(defaultRouteKey); !ok || got != forwardTime {
+		t.Errorf("got table.GetLastUsedTimestamp(%#v) = (%s, %t), want = (%s, true)", defaultRouteKey, got, ok, forwardTime)
+	}
+
+	// A (*, G) route is not returned for an (S, G) key.
+	wildcardKey := WildcardRouteKey(defaultMulticastAddress)
+	otherKey := stack.UnicastSourceAndMulticastDestination{Source: testutil.MustParse4("192.168.1.2"), Destination: defaultMulticastAddress}
+	table.AddInstalledRoute(wildcardKey, table.NewInstalledRoute(defaultRoute))
+	if _, ok := table.GetInstalledRoute(otherKey); ok {
+		t.Errorf("table.GetInstalledRoute(%#v) = (_, true) with only a (*, G) route, want = (_, false)", otherKey)
+	}
+}
+
 func TestGetLastUsedTimestampWithNoMatchingRoute(t *testing.T) {
 	table := RouteTable{}
 	defer table.Close()
@@ -510,6 +951,166 @@ func TestSetLastUsedTimestamp(t *testing.T) {
 	}
 }
 
+func TestConcurrentInsertsAcrossShards(t *testing.T) {
+	const (
+		numSources = 8
+		numGroups  = 64
+	)
+
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig()
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	pkt := newPacketBuffer("foo")
+	defer pkt.DecRef()
+
+	keysBySource := make([][]stack.UnicastSourceAndMulticastDestination, numSources)
+	for s := range keysBySource {
+		for g := 0; g < numGroups; g++ {
+			keysBySource[s] = append(keysBySource[s], stack.UnicastSourceAndMulticastDestination{
+				Source:      tcpip.Address([]byte{192, 168, 1, byte(s + 1)}),
+				Destination: tcpip.Address([]byte{224, 0, 1, byte(g + 1)}),
+			})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, keys := range keysBySource {
+		wg.Add(1)
+		go func(keys []stack.UnicastSourceAndMulticastDestination) {
+			defer wg.Done()
+			for _, key := range keys {
+				result, err := table.GetRouteOrInsertPending(key, pkt)
+				if err != nil {
+					t.Errorf("table.GetRouteOrInsertPending(%#v, _): %s", key, err)
+					continue
+				}
+				if result.GetRouteResultState != NoRouteFoundAndPendingInserted {
+					t.Errorf("got table.GetRouteOrInsertPending(%#v, _).GetRouteResultState = %s, want = %s", key, result.GetRouteResultState, NoRouteFoundAndPendingInserted)
+				}
+			}
+		}(keys)
+	}
+	wg.Wait()
+
+	// Every route must hold exactly the one packet queued for it.
+	for _, keys := range keysBySource {
+		wg.Add(1)
+		go func(keys []stack.UnicastSourceAndMulticastDestination) {
+			defer wg.Done()
+			for _, key := range keys {
+				pendingPackets := table.AddInstalledRoute(key, table.NewInstalledRoute(defaultRoute))
+				if got := len(pendingPackets); got != 1 {
+					t.Errorf("got len(table.AddInstalledRoute(%#v, _)) = %d, want = 1", key, got)
+				}
+				for _, pendingPkt := range pendingPackets {
+					pendingPkt.DecRef()
+				}
+			}
+		}(keys)
+	}
+	wg.Wait()
+
+	for _, keys := range keysBySource {
+		for _, key := range keys {
+			if _, ok := table.GetLastUsedTimestamp(key); !ok {
+				t.Errorf("table.GetLastUsedTimestamp(%#v) = (_, false), want = (_, true)", key)
+			}
+		}
+	}
+	if got := table.pendingRouteCount.Load(); got != 0 {
+		t.Errorf("got table.pendingRouteCount = %d, want = 0", got)
+	}
+	if got := table.pendingPacketCount.Load(); got != 0 {
+		t.Errorf("got table.pendingPacketCount = %d, want = 0", got)
+	}
+	if got, want := table.installedRouteCount.Load(), int64(numSources*numGroups); got != want {
+		t.Errorf("got table.installedRouteCount = %d, want = %d", got, want)
+	}
+}
+
+func BenchmarkConcurrentGetRouteOrInsertPending(b *testing.B) {
+	const numGroups = 64
+
+	table := RouteTable{}
+	defer table.Close()
+	config := defaultConfig()
+	if err := table.Init(config); err != nil {
+		b.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	keys := make([]stack.UnicastSourceAndMulticastDestination, 0, numGroups)
+	for g := 0; g < numGroups; g++ {
+		key := stack.UnicastSourceAndMulticastDestination{
+			Source:      defaultAddress,
+			Destination: tcpip.Address([]byte{224, 0, 1, byte(g + 1)}),
+		}
+		keys = append(keys, key)
+		table.AddInstalledRoute(key, table.NewInstalledRoute(defaultRoute))
+	}
+
+	pkt := newPacketBuffer("foo")
+	defer pkt.DecRef()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			key := keys[i%len(keys)]
+			if _, err := table.GetRouteOrInsertPending(key, pkt); err != nil {
+				b.Errorf("table.GetRouteOrInsertPending(%#v, _): %s", key, err)
+				return
+			}
+		}
+	})
+}
+
+func TestUseAfterClose(t *testing.T) {
+	clock := faketime.NewManualClock()
+	table := RouteTable{}
+	config := defaultConfig(withClock(clock))
+	if err := table.Init(config); err != nil {
+		t.Fatalf("table.Init(%#v): %s", config, err)
+	}
+
+	pkt := newPacketBuffer("hello")
+	defer pkt.DecRef()
+	if _, err := table.GetRouteOrInsertPending(defaultRouteKey, pkt); err != nil {
+		t.Fatalf("table.GetRouteOrInsertPending(%#v, %#v): %s", defaultRouteKey, pkt, err)
+	}
+
+	table.Close()
+
+	for i := range table.shards {
+		shard := &table.shards[i]
+		shard.pendingMu.RLock()
+		pendingRoutes := len(shard.pendingRoutes)
+		shard.pendingMu.RUnlock()
+		if pendingRoutes != 0 {
+			t.Errorf("got len(table.shards[%d].pendingRoutes) = %d after Close, want = 0", i, pendingRoutes)
+		}
+	}
+
+	if _, err := table.GetRouteOrInsertPending(defaultRouteKey, pkt); err != ErrClosed {
+		t.Errorf("got table.GetRouteOrInsertPending(%#v, %#v) = (_, %v) after Close, want = (_, %s)", defaultRouteKey, pkt, err, ErrClosed)
+	}
+
+	route := table.NewInstalledRoute(defaultRoute)
+	if pendingPackets := table.AddInstalledRoute(defaultRouteKey, route); pendingPackets != nil {
+		t.Errorf("table.AddInstalledRoute(%#v, %#v) = %#v after Close, want = nil", defaultRouteKey, route, pendingPackets)
+	}
+	if !table.RemoveInstalledRoute(defaultRouteKey) {
+		t.Errorf("table.RemoveInstalledRoute(%#v) = false after Close, want = true", defaultRouteKey)
+	}
+
+	// Neither advancing past the cleanup interval nor closing again should
+	// touch the released packets.
+	clock.Advance(DefaultCleanupInterval)
+	table.Close()
+}
+
 func TestMain(m *testing.M) {
 	refs.SetLeakMode(refs.LeaksPanic)
 	code := m.Run()
@@ -963,11 +963,24 @@ func (e *endpoint) forwardMulticastPacket(h header.IPv4, pkt stack.PacketBufferP
 
 	// The pkt has been validated. Consequently, if a route is not found, then
 	// the pkt can safely be queued.
-	result, hasBufferSpace := e.protocol.multicastRouteTable.GetRouteOrInsertPending(routeKey, pkt)
+	result, err := e.protocol.multicastRouteTable.GetRouteOrInsertPending(routeKey, pkt)
 
-	if !hasBufferSpace {
+	switch err {
+	case nil:
+	case multicast.ErrNoBufferSpace, multicast.ErrClosed:
 		// Unable to queue the pkt. Silently drop it.
 		return &ip.ErrNoMulticastPendingQueueBufferSpace{}
+	default:
+		// The pkt's addresses cannot identify a multicast route, e.g.
+		// multicast.ErrInvalidUnicastSource. Count it as a malformed packet.
+		return &ip.ErrParameterProblem{}
+	}
+
+	if evicted := result.EvictedPendingPackets; evicted != 0 {
+		// Older queued packets were dropped to make room for the pkt.
+		stats := e.stats.ip
+		stats.Forwarding.NoMulticastPendingQueueBufferSpace.IncrementBy(uint64(evicted))
+		stats.Forwarding.Errors.IncrementBy(uint64(evicted))
 	}
 
 	switch result.GetRouteResultState {
@@ -1041,8 +1054,8 @@ func (e *endpoint) forwardValidatedMulticastPacket(pkt stack.PacketBufferPtr, in
 		return &ip.ErrUnexpectedMulticastInputInterface{}
 	}
 
-	for _, outgoingInterface := range installedRoute.OutgoingInterfaces {
-		if err := e.forwardMulticastPacketForOutgoingInterface(pkt, outgoingInterface); err != nil {
+	for _, outgoingInterface := range installedRoute.OutgoingInterfaces() {
+		if err := e.forwardMulticastPacketForOutgoingInterface(pkt, installedRoute, outgoingInterface); err != nil {
 			e.handleForwardingError(err)
 			continue
 		}
@@ -1056,7 +1069,7 @@ func (e *endpoint) forwardValidatedMulticastPacket(pkt stack.PacketBufferPtr, in
 // of the provided outgoingInterface.
 //
 // This method should be invoked by the endpoint that received the pkt.
-func (e *endpoint) forwardMulticastPacketForOutgoingInterface(pkt stack.PacketBufferPtr, outgoingInterface stack.MulticastRouteOutgoingInterface) ip.ForwardingError {
+func (e *endpoint) forwardMulticastPacketForOutgoingInterface(pkt stack.PacketBufferPtr, installedRoute *multicast.InstalledRoute, outgoingInterface stack.MulticastRouteOutgoingInterface) ip.ForwardingError {
 	h := header.IPv4(pkt.NetworkHeader().Slice())
 
 	// Per RFC 1812 section 5.2.1.3,
@@ -1067,7 +1080,7 @@ func (e *endpoint) forwardMulticastPacketForOutgoingInterface(pkt stack.PacketBu
 	//
 	// Copying of the packet is deferred to forwardPacketWithRoute since unicast
 	// and multicast both require a copy.
-	if outgoingInterface.MinTTL > h.TTL() {
+	if !installedRoute.CheckMinTTL(outgoingInterface, h.TTL()) {
 		return &ip.ErrTTLExceeded{}
 	}
 
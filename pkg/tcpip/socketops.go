@@ -15,6 +15,10 @@
 package tcpip
 
 import (
+	"fmt"
+	"math"
+	"time"
+
 	"gvisor.dev/gvisor/pkg/atomicbitops"
 	"gvisor.dev/gvisor/pkg/bufferv2"
 	"gvisor.dev/gvisor/pkg/sync"
@@ -37,6 +41,9 @@ type SocketOptionsHandler interface {
 	// Note that v will be the inverse of TCP_NODELAY option.
 	OnDelayOptionSet(v bool)
 
+	// OnQuickAckSet is invoked when TCP_QUICKACK is set for an endpoint.
+	OnQuickAckSet(v bool)
+
 	// OnCorkOptionSet is invoked when TCP_CORK is set for an endpoint.
 	OnCorkOptionSet(v bool)
 
@@ -54,15 +61,116 @@ type SocketOptionsHandler interface {
 	// buffer size. It also returns the newly set value.
 	OnSetSendBufferSize(v int64) (newSz int64)
 
+	// OnSendBufferAutoTuneDisabled is invoked once, when the send buffer size
+	// is first set explicitly with SO_SNDBUF. The endpoint must stop
+	// autotuning its send buffer from then on.
+	OnSendBufferAutoTuneDisabled()
+
 	// OnSetReceiveBufferSize is invoked by SO_RCVBUF and SO_RCVBUFFORCE. The
 	// handler can optionally return a callback which will be called after
-	// the buffer size is updated to newSz.
+	// the buffer size is updated to newSz, e.g. to send a window update.
+	//
+	// Shrinking the buffer must never retract the right edge of a window that
+	// has already been advertised (RFC 793), it may only slow down the growth
+	// of the window. It is up to the handler to only announce a window update
+	// when the advertisable window grew.
 	OnSetReceiveBufferSize(v, oldSz int64) (newSz int64, postSet func())
 
+	// OnReceiveBufferAutoTuneDisabled is invoked once, when the receive buffer
+	// size is first set explicitly with SO_RCVBUF or SO_RCVBUFFORCE. The
+	// endpoint must stop autotuning its receive buffer from then on.
+	OnReceiveBufferAutoTuneDisabled()
+
 	// WakeupWriters is invoked when the send buffer size for an endpoint is
 	// changed. The handler notifies the writers if the send buffer size is
 	// increased with setsockopt(2) for TCP endpoints.
 	WakeupWriters()
+
+	// OnSendBufferFullChanged is invoked when the send buffer becomes full or
+	// stops being full, as detected by SocketOptions.UpdateSendBufferUsage.
+	// It is edge triggered: it is only invoked when the state changes. It may
+	// be invoked with the endpoint's send queue locked, so it must not call
+	// back into the endpoint's send path.
+	OnSendBufferFullChanged(full bool)
+
+	// AttachFilter is invoked when SO_ATTACH_FILTER is set for an endpoint.
+	// The endpoint is responsible for running prog on received packets.
+	//
+	// Endpoints which do not support filtering accept and ignore prog. This
+	// is intentional: netstack has always reported success for
+	// SO_ATTACH_FILTER, and applications commonly attach filters as an
+	// optimization rather than for correctness.
+	AttachFilter(prog []SockFilter) Error
+
+	// DetachFilter is invoked when SO_DETACH_FILTER is set for an endpoint.
+	// As with AttachFilter, endpoints which do not support filtering succeed.
+	DetachFilter() Error
+
+	// OnErrQueueNonEmpty is invoked when a socket error is queued onto an
+	// empty error queue. It is not invoked for errors queued while the queue
+	// already holds errors.
+	OnErrQueueNonEmpty()
+
+	// OnSelectErrQueueSet is invoked when SO_SELECT_ERR_QUEUE is set for an
+	// endpoint. The endpoint should re-evaluate its readiness, see
+	// SocketOptions.SelectErrQueueReady.
+	OnSelectErrQueueSet(v bool)
+
+	// OnPassSecSet is invoked when SO_PASSSEC is set for an endpoint. While it
+	// is enabled, the endpoint should attach the sender's security context to
+	// received messages as an SCM_SECURITY control message.
+	OnPassSecSet(v bool)
+
+	// OnMulticastAllSet is invoked when IP_MULTICAST_ALL is set for an
+	// endpoint. While it is enabled, the endpoint receives multicast packets
+	// for every group joined on the interface; otherwise it only receives
+	// packets for groups it joined itself. Datagram endpoints check
+	// SocketOptions.GetMulticastAll on delivery, so they need not implement
+	// it.
+	OnMulticastAllSet(v bool)
+
+	// OnBoundNICRemoved is invoked when the NIC the endpoint is bound to with
+	// SO_BINDTODEVICE is removed. The binding has already been cleared, so
+	// the handler may fail any pending operations that relied on it.
+	OnBoundNICRemoved(v int32)
+
+	// PathMTU returns the current path MTU of a connected endpoint. It returns
+	// ErrNotConnected if the endpoint is not connected.
+	PathMTU() (uint32, Error)
+
+	// PeerSec returns the security context of the endpoint's peer, as
+	// reported by SO_PEERSEC.
+	PeerSec() ([]byte, Error)
+
+	// NICIDForName is invoked to resolve a NIC name for SO_BINDTODEVICE.
+	NICIDForName(name string) (int32, bool)
+
+	// NICName is invoked to get the name of the NIC with the given ID.
+	NICName(v int32) (string, bool)
+
+	// InInitialState returns true if the endpoint is still in its initial
+	// state, i.e. it has not been bound, connected or put into listen mode.
+	// Options that may only be changed before then, such as IPV6_V6ONLY, are
+	// rejected once it returns false.
+	InInitialState() bool
+
+	// OnSetMulticastInterface is invoked when IP_MULTICAST_IF or
+	// IPV6_MULTICAST_IF is set for an endpoint. A zero NIC and empty address
+	// clear the selection. Datagram endpoints read the selection with
+	// SocketOptions.GetMulticastInterface when routing multicast packets and
+	// set it with their locks held, so the handler must not call back into
+	// the endpoint.
+	OnSetMulticastInterface(v MulticastInterfaceOption)
+
+	// SetSockOptInt is invoked by SocketOptions.SetInt to set an integer
+	// option which is held by the endpoint, e.g. IPv4TTLOption. Endpoints
+	// implement it with Endpoint.SetSockOptInt.
+	SetSockOptInt(opt SockOptInt, v int) Error
+
+	// GetSockOptInt is invoked by SocketOptions.GetInt to get an integer
+	// option which is held by the endpoint. Endpoints implement it with
+	// Endpoint.GetSockOptInt.
+	GetSockOptInt(opt SockOptInt) (int, Error)
 }
 
 // DefaultSocketOptionsHandler is an embeddable type that implements no-op
@@ -83,6 +191,9 @@ func (*DefaultSocketOptionsHandler) OnKeepAliveSet(bool) {}
 // OnDelayOptionSet implements SocketOptionsHandler.OnDelayOptionSet.
 func (*DefaultSocketOptionsHandler) OnDelayOptionSet(bool) {}
 
+// OnQuickAckSet implements SocketOptionsHandler.OnQuickAckSet.
+func (*DefaultSocketOptionsHandler) OnQuickAckSet(bool) {}
+
 // OnCorkOptionSet implements SocketOptionsHandler.OnCorkOptionSet.
 func (*DefaultSocketOptionsHandler) OnCorkOptionSet(bool) {}
 
@@ -104,14 +215,92 @@ func (*DefaultSocketOptionsHandler) OnSetSendBufferSize(v int64) (newSz int64) {
 	return v
 }
 
+// OnSendBufferAutoTuneDisabled implements
+// SocketOptionsHandler.OnSendBufferAutoTuneDisabled.
+func (*DefaultSocketOptionsHandler) OnSendBufferAutoTuneDisabled() {}
+
 // WakeupWriters implements SocketOptionsHandler.WakeupWriters.
 func (*DefaultSocketOptionsHandler) WakeupWriters() {}
 
+// OnSendBufferFullChanged implements
+// SocketOptionsHandler.OnSendBufferFullChanged.
+func (*DefaultSocketOptionsHandler) OnSendBufferFullChanged(bool) {}
+
 // OnSetReceiveBufferSize implements SocketOptionsHandler.OnSetReceiveBufferSize.
 func (*DefaultSocketOptionsHandler) OnSetReceiveBufferSize(v, oldSz int64) (newSz int64, postSet func()) {
 	return v, nil
 }
 
+// OnReceiveBufferAutoTuneDisabled implements
+// SocketOptionsHandler.OnReceiveBufferAutoTuneDisabled.
+func (*DefaultSocketOptionsHandler) OnReceiveBufferAutoTuneDisabled() {}
+
+// AttachFilter implements SocketOptionsHandler.AttachFilter. The filter is
+// accepted and ignored.
+func (*DefaultSocketOptionsHandler) AttachFilter([]SockFilter) Error {
+	return nil
+}
+
+// DetachFilter implements SocketOptionsHandler.DetachFilter. It always
+// succeeds, see AttachFilter.
+func (*DefaultSocketOptionsHandler) DetachFilter() Error {
+	return nil
+}
+
+// OnErrQueueNonEmpty implements SocketOptionsHandler.OnErrQueueNonEmpty.
+func (*DefaultSocketOptionsHandler) OnErrQueueNonEmpty() {}
+
+// OnSelectErrQueueSet implements SocketOptionsHandler.OnSelectErrQueueSet.
+func (*DefaultSocketOptionsHandler) OnSelectErrQueueSet(bool) {}
+
+// OnPassSecSet implements SocketOptionsHandler.OnPassSecSet.
+func (*DefaultSocketOptionsHandler) OnPassSecSet(bool) {}
+
+// OnMulticastAllSet implements SocketOptionsHandler.OnMulticastAllSet.
+func (*DefaultSocketOptionsHandler) OnMulticastAllSet(bool) {}
+
+// OnBoundNICRemoved implements SocketOptionsHandler.OnBoundNICRemoved.
+func (*DefaultSocketOptionsHandler) OnBoundNICRemoved(int32) {}
+
+// InInitialState implements SocketOptionsHandler.InInitialState.
+func (*DefaultSocketOptionsHandler) InInitialState() bool {
+	return true
+}
+
+// OnSetMulticastInterface implements
+// SocketOptionsHandler.OnSetMulticastInterface.
+func (*DefaultSocketOptionsHandler) OnSetMulticastInterface(MulticastInterfaceOption) {}
+
+// PathMTU implements SocketOptionsHandler.PathMTU.
+func (*DefaultSocketOptionsHandler) PathMTU() (uint32, Error) {
+	return 0, &ErrUnknownProtocolOption{}
+}
+
+// PeerSec implements SocketOptionsHandler.PeerSec.
+func (*DefaultSocketOptionsHandler) PeerSec() ([]byte, Error) {
+	return nil, &ErrUnknownProtocolOption{}
+}
+
+// NICIDForName implements SocketOptionsHandler.NICIDForName.
+func (*DefaultSocketOptionsHandler) NICIDForName(string) (int32, bool) {
+	return 0, false
+}
+
+// NICName implements SocketOptionsHandler.NICName.
+func (*DefaultSocketOptionsHandler) NICName(int32) (string, bool) {
+	return "", false
+}
+
+// SetSockOptInt implements SocketOptionsHandler.SetSockOptInt.
+func (*DefaultSocketOptionsHandler) SetSockOptInt(SockOptInt, int) Error {
+	return &ErrUnknownProtocolOption{}
+}
+
+// GetSockOptInt implements SocketOptionsHandler.GetSockOptInt.
+func (*DefaultSocketOptionsHandler) GetSockOptInt(SockOptInt) (int, Error) {
+	return 0, &ErrUnknownProtocolOption{}
+}
+
 // StackHandler holds methods to access the stack options. These must be
 // implemented by the stack.
 type StackHandler interface {
@@ -121,6 +310,9 @@ type StackHandler interface {
 	// TransportProtocolOption allows retrieving individual protocol level
 	// option values.
 	TransportProtocolOption(proto TransportProtocolNumber, option GettableTransportProtocolOption) Error
+
+	// Clock returns the clock used by the stack.
+	Clock() Clock
 }
 
 // SocketOptions contains all the variables which store values for SOL_SOCKET,
@@ -133,6 +325,12 @@ type SocketOptions struct {
 	// StackHandler is initialized at the creation time and will not change.
 	stackHandler StackHandler `state:"manual"`
 
+	// transportProtocol is the transport protocol implemented by the owning
+	// endpoint. It is zero if the endpoint did not record one, e.g. raw and
+	// packet endpoints, in which case protocol specific options are not
+	// applicable. It is initialized at the creation time and will not change.
+	transportProtocol TransportProtocolNumber
+
 	// These fields are accessed and modified using atomic operations.
 
 	// broadcastEnabled determines whether datagram sockets are allowed to
@@ -143,6 +341,10 @@ type SocketOptions struct {
 	// messages are enabled.
 	passCredEnabled atomicbitops.Uint32
 
+	// passSecEnabled determines whether SCM_SECURITY socket control messages
+	// are enabled.
+	passSecEnabled atomicbitops.Uint32
+
 	// noChecksumEnabled determines whether UDP checksum is disabled while
 	// transmitting for this socket.
 	noChecksumEnabled atomicbitops.Uint32
@@ -163,6 +365,12 @@ type SocketOptions struct {
 	// non-loopback interface will be looped back.
 	multicastLoopEnabled atomicbitops.Uint32
 
+	// multicastAllDisabled determines whether multicast packets for groups
+	// joined on the interface by other sockets are withheld from this socket.
+	// It is inverted so that IP_MULTICAST_ALL is enabled by default, as on
+	// Linux, for every socket.
+	multicastAllDisabled atomicbitops.Uint32
+
 	// receiveTOSEnabled is used to specify if the TOS ancillary message is
 	// passed with incoming packets.
 	receiveTOSEnabled atomicbitops.Uint32
@@ -197,7 +405,8 @@ type SocketOptions struct {
 	v6OnlyEnabled atomicbitops.Uint32
 
 	// quickAckEnabled is used to represent the value of TCP_QUICKACK option.
-	// It currently does not have any effect on the TCP endpoint.
+	// The handler is notified when it is set and clears it with
+	// ConsumeQuickAck once it has acted on it.
 	quickAckEnabled atomicbitops.Uint32
 
 	// delayOptionEnabled is used to specify if data should be sent out immediately
@@ -221,6 +430,14 @@ type SocketOptions struct {
 	// passing is enabled for IPv6.
 	ipv6RecvErrEnabled atomicbitops.Uint32
 
+	// selectErrQueueEnabled determines whether a non-empty error queue is
+	// reported as an urgent (POLLPRI) readiness event.
+	selectErrQueueEnabled atomicbitops.Uint32
+
+	// lockFilterEnabled determines whether the attached socket filter is
+	// locked. Once set, it cannot be cleared.
+	lockFilterEnabled atomicbitops.Uint32
+
 	// errQueue is the per-socket error queue. It is protected by errQueueMu.
 	errQueueMu sync.Mutex `state:"nosave"`
 	errQueue   sockErrorList
@@ -236,6 +453,13 @@ type SocketOptions struct {
 	// sendBufferSize determines the send buffer size for this socket.
 	sendBufferSize atomicbitops.Int64
 
+	// sendBufferUsed is the number of bytes of the send buffer in use, as
+	// last reported through UpdateSendBufferUsage.
+	sendBufferUsed atomicbitops.Int64
+
+	// sendBufferFull is set while sendBufferUsed is at least sendBufferSize.
+	sendBufferFull atomicbitops.Bool
+
 	// getReceiveBufferLimits provides the handler to get the min, default and
 	// max size for receive buffer. It is initialized at the creation time and
 	// will not change.
@@ -244,16 +468,78 @@ type SocketOptions struct {
 	// receiveBufferSize determines the receive buffer size for this socket.
 	receiveBufferSize atomicbitops.Int64
 
+	// rcvlowat specifies the minimum number of bytes which should be
+	// received to indicate the socket as readable.
+	rcvlowat atomicbitops.Int32
+
+	// mark is the value of the SO_MARK option.
+	mark atomicbitops.Uint32
+
+	// priority is the value of the SO_PRIORITY option.
+	priority atomicbitops.Uint32
+
+	// sendBufAutoTuneDisabled is set once the send buffer size is set
+	// explicitly, after which it must not be autotuned.
+	sendBufAutoTuneDisabled atomicbitops.Uint32
+
+	// rcvBufAutoTuneDisabled is set once the receive buffer size is set
+	// explicitly, after which it must not be autotuned.
+	rcvBufAutoTuneDisabled atomicbitops.Uint32
+
+	// lastPathMTU is the path MTU most recently reported through QueueMTUErr,
+	// or 0 if none has been reported.
+	lastPathMTU atomicbitops.Uint32
+
+	// coalesceLocalErrsEnabled determines whether QueueLocalErr drops an error
+	// that is equal to the error at the back of the error queue.
+	coalesceLocalErrsEnabled atomicbitops.Uint32
+
 	// mu protects the access to the below fields.
+	//
+	// mu and errQueueMu are never held at the same time. Handler callbacks
+	// must not be invoked while holding mu, as handlers may call back into
+	// SocketOptions.
 	mu sync.Mutex `state:"nosave"`
 
 	// linger determines the amount of time the socket should linger before
 	// close. We currently implement this option for TCP socket only.
+	//
+	// +checklocks:mu
 	linger LingerOption
 
-	// rcvlowat specifies the minimum number of bytes which should be
-	// received to indicate the socket as readable.
-	rcvlowat atomicbitops.Int32
+	// keepAliveParams holds the keepalive parameters of this socket. The zero
+	// value means they have not been set yet.
+	//
+	// +checklocks:mu
+	keepAliveParams KeepAliveParams
+
+	// originalDst is the original destination of the most recently received
+	// datagram, recorded while IP(V6)_RECVORIGDSTADDR is enabled.
+	//
+	// +checklocks:mu
+	originalDst FullAddress
+
+	// hasOriginalDst indicates whether originalDst is set.
+	//
+	// +checklocks:mu
+	hasOriginalDst bool
+
+	// multicastInterface is the interface selected for outgoing multicast
+	// packets with IP(V6)_MULTICAST_IF.
+	//
+	// +checklocks:mu
+	multicastInterface MulticastInterfaceOption
+}
+
+// assertInitialState returns ErrInvalidEndpointState if the backing endpoint
+// is no longer in its initial state. Setters of options that may only be
+// changed before the endpoint is bound, connected or listening must call it
+// before changing any state.
+func (so *SocketOptions) assertInitialState() Error {
+	if !so.handler.InInitialState() {
+		return &ErrInvalidEndpointState{}
+	}
+	return nil
 }
 
 // InitHandler initializes the handler. This must be called before using the
@@ -265,6 +551,34 @@ func (so *SocketOptions) InitHandler(handler SocketOptionsHandler, stack StackHa
 	so.getReceiveBufferLimits = getReceiveBufferLimits
 }
 
+// tcpProtocolNumber is the TCP transport protocol number. It mirrors
+// header.TCPProtocolNumber, which cannot be imported from this package.
+const tcpProtocolNumber TransportProtocolNumber = 6
+
+// SetTransportProtocol records the transport protocol implemented by the
+// owning endpoint. It is used to reject options which are not applicable to
+// the socket and should be called right after InitHandler.
+func (so *SocketOptions) SetTransportProtocol(proto TransportProtocolNumber) {
+	so.transportProtocol = proto
+}
+
+// SupportsTCPOptions returns whether SOL_TCP level options are applicable to
+// the socket.
+func (so *SocketOptions) SupportsTCPOptions() bool {
+	return so.transportProtocol == tcpProtocolNumber
+}
+
+// NewSocketOptions returns a SocketOptions initialized with the provided
+// handlers. The send and receive buffer sizes are set to the defaults reported
+// by the respective limits, without notifying the handler.
+func NewSocketOptions(handler SocketOptionsHandler, stack StackHandler, getSendBufferLimits GetSendBufferLimits, getReceiveBufferLimits GetReceiveBufferLimits) *SocketOptions {
+	so := &SocketOptions{}
+	so.InitHandler(handler, stack, getSendBufferLimits, getReceiveBufferLimits)
+	so.SetSendBufferSize(int64(getSendBufferLimits(stack).Default), false /* notify */)
+	so.SetReceiveBufferSize(int64(getReceiveBufferLimits(stack).Default), false /* notify */)
+	return so
+}
+
 func storeAtomicBool(addr *atomicbitops.Uint32, v bool) {
 	var val uint32
 	if v {
@@ -278,6 +592,22 @@ func (so *SocketOptions) SetLastError(err Error) {
 	so.handler.UpdateLastError(err)
 }
 
+// SetConnectError records err as the result of a failed asynchronous
+// connect(2) on a socket using the network protocol net. The error is
+// reported through SO_ERROR and, if IP_RECVERR/IPV6_RECVERR is enabled for
+// net, also queued onto the error queue as a local error.
+func (so *SocketOptions) SetConnectError(err Error, net NetworkProtocolNumber) {
+	so.SetLastError(err)
+
+	recvErr := so.GetIPv4RecvError()
+	if net == ipv6ProtocolNumber {
+		recvErr = so.GetIPv6RecvError()
+	}
+	if recvErr {
+		so.QueueLocalErr(err, net, 0 /* info */, FullAddress{}, nil /* payload */)
+	}
+}
+
 // GetBroadcast gets value for SO_BROADCAST option.
 func (so *SocketOptions) GetBroadcast() bool {
 	return so.broadcastEnabled.Load() != 0
@@ -298,6 +628,17 @@ func (so *SocketOptions) SetPassCred(v bool) {
 	storeAtomicBool(&so.passCredEnabled, v)
 }
 
+// GetPassSec gets value for SO_PASSSEC option.
+func (so *SocketOptions) GetPassSec() bool {
+	return so.passSecEnabled.Load() != 0
+}
+
+// SetPassSec sets value for SO_PASSSEC option.
+func (so *SocketOptions) SetPassSec(v bool) {
+	storeAtomicBool(&so.passSecEnabled, v)
+	so.handler.OnPassSecSet(v)
+}
+
 // GetNoChecksum gets value for SO_NO_CHECK option.
 func (so *SocketOptions) GetNoChecksum() bool {
 	return so.noChecksumEnabled.Load() != 0
@@ -336,11 +677,54 @@ func (so *SocketOptions) GetKeepAlive() bool {
 }
 
 // SetKeepAlive sets value for SO_KEEPALIVE option.
+//
+// When keepalive is enabled on a TCP socket whose keepalive parameters are
+// unset, they are seeded from the stack's TCP defaults.
 func (so *SocketOptions) SetKeepAlive(v bool) {
+	// Only TCP sockets have keepalive defaults. Other sockets, e.g. AF_UNIX
+	// ones which never set a transport protocol, must not query the stack.
+	if v && so.transportProtocol == tcpProtocolNumber && so.GetKeepAliveParams() == (KeepAliveParams{}) {
+		var defaults TCPKeepaliveDefaultsOption
+		if err := so.stackHandler.TransportProtocolOption(tcpProtocolNumber, &defaults); err == nil {
+			so.mu.Lock()
+			if so.keepAliveParams == (KeepAliveParams{}) {
+				so.keepAliveParams = KeepAliveParams(defaults)
+			}
+			so.mu.Unlock()
+		}
+	}
 	storeAtomicBool(&so.keepAliveEnabled, v)
 	so.handler.OnKeepAliveSet(v)
 }
 
+// KeepAliveParams holds the TCP keepalive parameters of a socket.
+type KeepAliveParams struct {
+	// Idle is the time a connection must remain idle before the first
+	// keepalive probe is sent.
+	Idle time.Duration
+
+	// Interval is the time between keepalive probes.
+	Interval time.Duration
+
+	// Count is the number of unacknowledged probes sent before the
+	// connection is dropped.
+	Count int
+}
+
+// GetKeepAliveParams returns the keepalive parameters of the socket.
+func (so *SocketOptions) GetKeepAliveParams() KeepAliveParams {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	return so.keepAliveParams
+}
+
+// SetKeepAliveParams sets the keepalive parameters of the socket.
+func (so *SocketOptions) SetKeepAliveParams(p KeepAliveParams) {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	so.keepAliveParams = p
+}
+
 // GetMulticastLoop gets value for IP_MULTICAST_LOOP option.
 func (so *SocketOptions) GetMulticastLoop() bool {
 	return so.multicastLoopEnabled.Load() != 0
@@ -351,6 +735,17 @@ func (so *SocketOptions) SetMulticastLoop(v bool) {
 	storeAtomicBool(&so.multicastLoopEnabled, v)
 }
 
+// GetMulticastAll gets value for IP_MULTICAST_ALL option.
+func (so *SocketOptions) GetMulticastAll() bool {
+	return so.multicastAllDisabled.Load() == 0
+}
+
+// SetMulticastAll sets value for IP_MULTICAST_ALL option.
+func (so *SocketOptions) SetMulticastAll(v bool) {
+	storeAtomicBool(&so.multicastAllDisabled, !v)
+	so.handler.OnMulticastAllSet(v)
+}
+
 // GetReceiveTOS gets value for IP_RECVTOS option.
 func (so *SocketOptions) GetReceiveTOS() bool {
 	return so.receiveTOSEnabled.Load() != 0
@@ -428,9 +823,14 @@ func (so *SocketOptions) GetV6Only() bool {
 
 // SetV6Only sets value for IPV6_V6ONLY option.
 //
-// Preconditions: the backing TCP or UDP endpoint must be in initial state.
-func (so *SocketOptions) SetV6Only(v bool) {
+// Returns ErrInvalidEndpointState if the backing endpoint is no longer in its
+// initial state.
+func (so *SocketOptions) SetV6Only(v bool) Error {
+	if err := so.assertInitialState(); err != nil {
+		return err
+	}
 	storeAtomicBool(&so.v6OnlyEnabled, v)
+	return nil
 }
 
 // GetQuickAck gets value for TCP_QUICKACK option.
@@ -439,8 +839,20 @@ func (so *SocketOptions) GetQuickAck() bool {
 }
 
 // SetQuickAck sets value for TCP_QUICKACK option.
-func (so *SocketOptions) SetQuickAck(v bool) {
+func (so *SocketOptions) SetQuickAck(v bool) Error {
+	if !so.SupportsTCPOptions() {
+		return &ErrUnknownProtocolOption{}
+	}
 	storeAtomicBool(&so.quickAckEnabled, v)
+	so.handler.OnQuickAckSet(v)
+	return nil
+}
+
+// ConsumeQuickAck clears the TCP_QUICKACK option and returns its previous
+// value. Like Linux, quick ACK mode is one-shot: the handler calls this once
+// it has acknowledged the segments the option applied to.
+func (so *SocketOptions) ConsumeQuickAck() bool {
+	return so.quickAckEnabled.Swap(0) != 0
 }
 
 // GetDelayOption gets inverted value for TCP_NODELAY option.
@@ -449,9 +861,13 @@ func (so *SocketOptions) GetDelayOption() bool {
 }
 
 // SetDelayOption sets inverted value for TCP_NODELAY option.
-func (so *SocketOptions) SetDelayOption(v bool) {
+func (so *SocketOptions) SetDelayOption(v bool) Error {
+	if !so.SupportsTCPOptions() {
+		return &ErrUnknownProtocolOption{}
+	}
 	storeAtomicBool(&so.delayOptionEnabled, v)
 	so.handler.OnDelayOptionSet(v)
+	return nil
 }
 
 // GetCorkOption gets value for TCP_CORK option.
@@ -460,9 +876,13 @@ func (so *SocketOptions) GetCorkOption() bool {
 }
 
 // SetCorkOption sets value for TCP_CORK option.
-func (so *SocketOptions) SetCorkOption(v bool) {
+func (so *SocketOptions) SetCorkOption(v bool) Error {
+	if !so.SupportsTCPOptions() {
+		return &ErrUnknownProtocolOption{}
+	}
 	storeAtomicBool(&so.corkOptionEnabled, v)
 	so.handler.OnCorkOptionSet(v)
+	return nil
 }
 
 // GetReceiveOriginalDstAddress gets value for IP(V6)_RECVORIGDSTADDR option.
@@ -471,8 +891,109 @@ func (so *SocketOptions) GetReceiveOriginalDstAddress() bool {
 }
 
 // SetReceiveOriginalDstAddress sets value for IP(V6)_RECVORIGDSTADDR option.
+// Disabling the option forgets any recorded original destination.
 func (so *SocketOptions) SetReceiveOriginalDstAddress(v bool) {
 	storeAtomicBool(&so.receiveOriginalDstAddress, v)
+	if !v {
+		so.mu.Lock()
+		so.originalDst = FullAddress{}
+		so.hasOriginalDst = false
+		so.mu.Unlock()
+	}
+}
+
+// RecordOriginalDstAddress records addr as the original destination of the
+// most recently received datagram if IP(V6)_RECVORIGDSTADDR is enabled.
+// Returns true if the address was recorded.
+func (so *SocketOptions) RecordOriginalDstAddress(addr FullAddress) bool {
+	if !so.GetReceiveOriginalDstAddress() {
+		return false
+	}
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	so.originalDst = addr
+	so.hasOriginalDst = true
+	return true
+}
+
+// LastOriginalDstAddress returns the original destination recorded by
+// RecordOriginalDstAddress. Returns false if none was recorded.
+func (so *SocketOptions) LastOriginalDstAddress() (FullAddress, bool) {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	return so.originalDst, so.hasOriginalDst
+}
+
+// GetSelectErrQueue gets value for SO_SELECT_ERR_QUEUE option.
+func (so *SocketOptions) GetSelectErrQueue() bool {
+	return so.selectErrQueueEnabled.Load() != 0
+}
+
+// SetSelectErrQueue sets value for SO_SELECT_ERR_QUEUE option.
+func (so *SocketOptions) SetSelectErrQueue(v bool) {
+	storeAtomicBool(&so.selectErrQueueEnabled, v)
+	so.handler.OnSelectErrQueueSet(v)
+}
+
+// CmsgMask is a bitmask of the ancillary messages which should be attached to
+// received packets.
+type CmsgMask uint32
+
+const (
+	// CmsgMaskTOS is set if IP_RECVTOS is enabled.
+	CmsgMaskTOS CmsgMask = 1 << iota
+
+	// CmsgMaskTTL is set if IP_RECVTTL is enabled.
+	CmsgMaskTTL
+
+	// CmsgMaskHopLimit is set if IPV6_RECVHOPLIMIT is enabled.
+	CmsgMaskHopLimit
+
+	// CmsgMaskTClass is set if IPV6_RECVTCLASS is enabled.
+	CmsgMaskTClass
+
+	// CmsgMaskPacketInfo is set if IP_PKTINFO is enabled.
+	CmsgMaskPacketInfo
+
+	// CmsgMaskIPv6PacketInfo is set if IPV6_RECVPKTINFO is enabled.
+	CmsgMaskIPv6PacketInfo
+
+	// CmsgMaskOriginalDstAddress is set if IP(V6)_RECVORIGDSTADDR is enabled.
+	CmsgMaskOriginalDstAddress
+)
+
+// Has returns whether all bits in m are set.
+func (c CmsgMask) Has(m CmsgMask) bool {
+	return c&m == m
+}
+
+// ReceiveCmsgMask returns the set of ancillary messages which are enabled for
+// received packets. It lets the receive path evaluate all the relevant options
+// at once instead of calling the individual getters for each packet.
+func (so *SocketOptions) ReceiveCmsgMask() CmsgMask {
+	var m CmsgMask
+	if so.receiveTOSEnabled.Load() != 0 {
+		m |= CmsgMaskTOS
+	}
+	if so.receiveTTLEnabled.Load() != 0 {
+		m |= CmsgMaskTTL
+	}
+	if so.receiveHopLimitEnabled.Load() != 0 {
+		m |= CmsgMaskHopLimit
+	}
+	if so.receiveTClassEnabled.Load() != 0 {
+		m |= CmsgMaskTClass
+	}
+	if so.receivePacketInfoEnabled.Load() != 0 {
+		m |= CmsgMaskPacketInfo
+	}
+	if so.receiveIPv6PacketInfoEnabled.Load() != 0 {
+		m |= CmsgMaskIPv6PacketInfo
+	}
+	if so.receiveOriginalDstAddress.Load() != 0 {
+		m |= CmsgMaskOriginalDstAddress
+	}
+	return m
 }
 
 // GetIPv4RecvError gets value for IP_RECVERR option.
@@ -501,11 +1022,145 @@ func (so *SocketOptions) SetIPv6RecvError(v bool) {
 	}
 }
 
+// SetRecvError sets value for both IP_RECVERR and IPV6_RECVERR options. When
+// disabling, the error queue is pruned once.
+func (so *SocketOptions) SetRecvError(v bool) {
+	storeAtomicBool(&so.ipv4RecvErrEnabled, v)
+	storeAtomicBool(&so.ipv6RecvErrEnabled, v)
+	if !v {
+		so.pruneErrQueue()
+	}
+}
+
 // GetLastError gets value for SO_ERROR option.
 func (so *SocketOptions) GetLastError() Error {
 	return so.handler.LastError()
 }
 
+// SetInt sets the value of the integer option opt. It provides a single entry
+// point for the setsockopt(2) implementations. Like setsockopt(2), buffer
+// sizes are clamped to the buffer limits and multiplied by
+// BufferOverheadFactor before they are stored, see requestedBufferSize.
+//
+// Options which are held by the endpoint are set with
+// SocketOptionsHandler.SetSockOptInt.
+func (so *SocketOptions) SetInt(opt SocketOptionInt, v int64) Error {
+	switch opt {
+	case SocketSendBufferSizeOption:
+		min, max := so.SendBufferLimits()
+		so.SetSendBufferSize(requestedBufferSize(v, min, max, false /* ignoreMax */), true /* notify */)
+		return nil
+	case SocketReceiveBufferSizeOption, SocketReceiveBufferSizeForceOption:
+		min, max := so.ReceiveBufferLimits()
+		ignoreMax := opt == SocketReceiveBufferSizeForceOption
+		so.SetReceiveBufferSize(requestedBufferSize(v, min, max, ignoreMax), true /* notify */)
+		return nil
+	case ReceiveLowWaterMarkOption:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return &ErrInvalidOptionValue{}
+		}
+		return so.SetRcvlowat(int32(v))
+	case BindToDeviceOption:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return &ErrInvalidOptionValue{}
+		}
+		return so.SetBindToDevice(int32(v))
+	case MarkOption:
+		if v < 0 || v > math.MaxUint32 {
+			return &ErrInvalidOptionValue{}
+		}
+		so.SetMark(uint32(v))
+		return nil
+	case PriorityOption:
+		if v < 0 || v > math.MaxUint32 {
+			return &ErrInvalidOptionValue{}
+		}
+		so.SetPriority(uint32(v))
+		return nil
+	case UnicastTTLOption, UnicastHopLimitOption:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return &ErrInvalidOptionValue{}
+		}
+		endpointOpt, err := opt.endpointOption()
+		if err != nil {
+			return err
+		}
+		return so.handler.SetSockOptInt(endpointOpt, int(v))
+	default:
+		return &ErrUnknownProtocolOption{}
+	}
+}
+
+// requestedBufferSize returns the buffer size to store for a size of v
+// requested with setsockopt(2). Like Linux, v is capped to max unless
+// ignoreMax is set (SO_RCVBUFFORCE) and multiplied by BufferOverheadFactor,
+// and the result is at least min. Sizes that would overflow are stored as
+// MaxSocketBufferSize.
+func requestedBufferSize(v, min, max int64, ignoreMax bool) int64 {
+	if !ignoreMax && v > max {
+		v = max
+	}
+	if v >= MaxSocketBufferSize/BufferOverheadFactor {
+		return MaxSocketBufferSize
+	}
+	v *= BufferOverheadFactor
+	if v < min {
+		v = min
+	}
+	return v
+}
+
+// GetInt gets the value of the integer option opt. It provides a single entry
+// point for the getsockopt(2) implementations.
+//
+// Options which are held by the endpoint are retrieved with
+// SocketOptionsHandler.GetSockOptInt.
+func (so *SocketOptions) GetInt(opt SocketOptionInt) (int64, Error) {
+	switch opt {
+	case SocketSendBufferSizeOption:
+		return so.GetSendBufferSize(), nil
+	case SocketReceiveBufferSizeOption, SocketReceiveBufferSizeForceOption:
+		return so.GetReceiveBufferSize(), nil
+	case ReceiveLowWaterMarkOption:
+		return int64(so.GetRcvlowat()), nil
+	case BindToDeviceOption:
+		return int64(so.GetBindToDevice()), nil
+	case MarkOption:
+		return int64(so.GetMark()), nil
+	case PriorityOption:
+		return int64(so.GetPriority()), nil
+	case UnicastTTLOption, UnicastHopLimitOption:
+		endpointOpt, err := opt.endpointOption()
+		if err != nil {
+			return 0, err
+		}
+		v, err := so.handler.GetSockOptInt(endpointOpt)
+		return int64(v), err
+	default:
+		return 0, &ErrUnknownProtocolOption{}
+	}
+}
+
+// GetMark gets value for SO_MARK option.
+func (so *SocketOptions) GetMark() uint32 {
+	return so.mark.Load()
+}
+
+// SetMark sets value for SO_MARK option.
+func (so *SocketOptions) SetMark(v uint32) {
+	so.mark.Store(v)
+}
+
+// GetPriority gets value for SO_PRIORITY option.
+func (so *SocketOptions) GetPriority() uint32 {
+	return so.priority.Load()
+}
+
+// SetPriority sets value for SO_PRIORITY option.
+func (so *SocketOptions) SetPriority(v uint32) {
+	so.priority.Store(v)
+}
+
 // GetOutOfBandInline gets value for SO_OOBINLINE option.
 func (*SocketOptions) GetOutOfBandInline() bool {
 	return true
@@ -515,6 +1170,394 @@ func (*SocketOptions) GetOutOfBandInline() bool {
 // support disabling this option.
 func (*SocketOptions) SetOutOfBandInline(bool) {}
 
+// SockOptBool represents socket options which values have the bool type and
+// are stored in SocketOptions.
+type SockOptBool int
+
+const (
+	// BroadcastOption is used by SetBool/GetBool to specify SO_BROADCAST.
+	BroadcastOption SockOptBool = iota
+
+	// PassCredOption is used by SetBool/GetBool to specify SO_PASSCRED.
+	PassCredOption
+
+	// NoChecksumOption is used by SetBool/GetBool to specify SO_NO_CHECK.
+	NoChecksumOption
+
+	// ReuseAddressOption is used by SetBool/GetBool to specify SO_REUSEADDR.
+	ReuseAddressOption
+
+	// ReusePortOption is used by SetBool/GetBool to specify SO_REUSEPORT.
+	ReusePortOption
+
+	// KeepAliveOption is used by SetBool/GetBool to specify SO_KEEPALIVE.
+	KeepAliveOption
+
+	// OutOfBandInlineOption is used by SetBool/GetBool to specify
+	// SO_OOBINLINE.
+	OutOfBandInlineOption
+
+	// LockFilterOption is used by SetBool/GetBool to specify SO_LOCK_FILTER.
+	LockFilterOption
+
+	// MulticastLoopOption is used by SetBool/GetBool to specify
+	// IP_MULTICAST_LOOP.
+	MulticastLoopOption
+
+	// ReceiveTOSOption is used by SetBool/GetBool to specify IP_RECVTOS.
+	ReceiveTOSOption
+
+	// ReceiveTTLOption is used by SetBool/GetBool to specify IP_RECVTTL.
+	ReceiveTTLOption
+
+	// ReceiveHopLimitOption is used by SetBool/GetBool to specify
+	// IPV6_RECVHOPLIMIT.
+	ReceiveHopLimitOption
+
+	// ReceiveTClassOption is used by SetBool/GetBool to specify
+	// IPV6_RECVTCLASS.
+	ReceiveTClassOption
+
+	// ReceivePacketInfoOption is used by SetBool/GetBool to specify
+	// IP_PKTINFO.
+	ReceivePacketInfoOption
+
+	// IPv6ReceivePacketInfoOption is used by SetBool/GetBool to specify
+	// IPV6_RECVPKTINFO.
+	IPv6ReceivePacketInfoOption
+
+	// HeaderIncludedOption is used by SetBool/GetBool to specify IP_HDRINCL.
+	HeaderIncludedOption
+
+	// V6OnlyOption is used by SetBool/GetBool to specify IPV6_V6ONLY.
+	V6OnlyOption
+
+	// ReceiveOriginalDstAddressOption is used by SetBool/GetBool to specify
+	// IP(V6)_RECVORIGDSTADDR.
+	ReceiveOriginalDstAddressOption
+
+	// IPv4RecvErrorOption is used by SetBool/GetBool to specify IP_RECVERR.
+	IPv4RecvErrorOption
+
+	// IPv6RecvErrorOption is used by SetBool/GetBool to specify
+	// IPV6_RECVERR.
+	IPv6RecvErrorOption
+
+	// QuickAckOption is used by SetBool/GetBool to specify TCP_QUICKACK.
+	QuickAckOption
+
+	// DelayOption is used by SetBool/GetBool to specify the inverse of
+	// TCP_NODELAY.
+	DelayOption
+
+	// CorkOption is used by SetBool/GetBool to specify TCP_CORK.
+	CorkOption
+
+	// SelectErrQueueOption is used by SetBool/GetBool to specify
+	// SO_SELECT_ERR_QUEUE.
+	SelectErrQueueOption
+
+	// PassSecOption is used by SetBool/GetBool to specify SO_PASSSEC.
+	PassSecOption
+
+	// MulticastAllOption is used by SetBool/GetBool to specify
+	// IP_MULTICAST_ALL.
+	MulticastAllOption
+
+	// numSockOptBools is the number of SockOptBool values. It must remain last.
+	numSockOptBools
+)
+
+// SocketOptionInt represents socket options which values have an integer type
+// and are dispatched by SetInt/GetInt.
+type SocketOptionInt int
+
+const (
+	// SocketSendBufferSizeOption is used by SetInt/GetInt to specify
+	// SO_SNDBUF.
+	SocketSendBufferSizeOption SocketOptionInt = iota
+
+	// SocketReceiveBufferSizeOption is used by SetInt/GetInt to specify
+	// SO_RCVBUF.
+	SocketReceiveBufferSizeOption
+
+	// SocketReceiveBufferSizeForceOption is used by SetInt/GetInt to specify
+	// SO_RCVBUFFORCE. Unlike SocketReceiveBufferSizeOption, the receive buffer
+	// limit does not apply. Callers must check for CAP_NET_ADMIN.
+	SocketReceiveBufferSizeForceOption
+
+	// ReceiveLowWaterMarkOption is used by SetInt/GetInt to specify
+	// SO_RCVLOWAT.
+	ReceiveLowWaterMarkOption
+
+	// BindToDeviceOption is used by SetInt/GetInt to specify the NIC ID set
+	// with SO_BINDTODEVICE.
+	BindToDeviceOption
+
+	// MarkOption is used by SetInt/GetInt to specify SO_MARK.
+	MarkOption
+
+	// PriorityOption is used by SetInt/GetInt to specify SO_PRIORITY.
+	PriorityOption
+
+	// UnicastTTLOption is used by SetInt/GetInt to specify IP_TTL. It is held
+	// by the endpoint as IPv4TTLOption.
+	UnicastTTLOption
+
+	// UnicastHopLimitOption is used by SetInt/GetInt to specify
+	// IPV6_UNICAST_HOPS. It is held by the endpoint as IPv6HopLimitOption.
+	UnicastHopLimitOption
+
+	// numSocketOptionInts is the number of SocketOptionInt values. It must
+	// remain last.
+	numSocketOptionInts
+)
+
+// endpointOption returns the SockOptInt with which the endpoint holds opt. It
+// returns ErrUnknownProtocolOption if opt is not held by the endpoint.
+func (opt SocketOptionInt) endpointOption() (SockOptInt, Error) {
+	switch opt {
+	case UnicastTTLOption:
+		return IPv4TTLOption, nil
+	case UnicastHopLimitOption:
+		return IPv6HopLimitOption, nil
+	default:
+		return 0, &ErrUnknownProtocolOption{}
+	}
+}
+
+// SetBool sets the value of the boolean option opt. It provides a single
+// entry point for the setsockopt(2) implementations.
+//
+// Options which are unknown or do not apply to the socket's transport protocol
+// return ErrUnknownProtocolOption (ENOPROTOOPT), as opposed to
+// ErrInvalidOptionValue (EINVAL) which is reserved for bad values.
+func (so *SocketOptions) SetBool(opt SockOptBool, v bool) Error {
+	switch opt {
+	case BroadcastOption:
+		so.SetBroadcast(v)
+	case PassCredOption:
+		so.SetPassCred(v)
+	case NoChecksumOption:
+		so.SetNoChecksum(v)
+	case ReuseAddressOption:
+		so.SetReuseAddress(v)
+	case ReusePortOption:
+		so.SetReusePort(v)
+	case KeepAliveOption:
+		so.SetKeepAlive(v)
+	case OutOfBandInlineOption:
+		so.SetOutOfBandInline(v)
+	case LockFilterOption:
+		return so.SetLockFilter(v)
+	case MulticastLoopOption:
+		so.SetMulticastLoop(v)
+	case ReceiveTOSOption:
+		so.SetReceiveTOS(v)
+	case ReceiveTTLOption:
+		so.SetReceiveTTL(v)
+	case ReceiveHopLimitOption:
+		so.SetReceiveHopLimit(v)
+	case ReceiveTClassOption:
+		so.SetReceiveTClass(v)
+	case ReceivePacketInfoOption:
+		so.SetReceivePacketInfo(v)
+	case IPv6ReceivePacketInfoOption:
+		so.SetIPv6ReceivePacketInfo(v)
+	case HeaderIncludedOption:
+		so.SetHeaderIncluded(v)
+	case V6OnlyOption:
+		return so.SetV6Only(v)
+	case ReceiveOriginalDstAddressOption:
+		so.SetReceiveOriginalDstAddress(v)
+	case IPv4RecvErrorOption:
+		so.SetIPv4RecvError(v)
+	case IPv6RecvErrorOption:
+		so.SetIPv6RecvError(v)
+	case QuickAckOption:
+		return so.SetQuickAck(v)
+	case DelayOption:
+		return so.SetDelayOption(v)
+	case CorkOption:
+		return so.SetCorkOption(v)
+	case SelectErrQueueOption:
+		so.SetSelectErrQueue(v)
+	case PassSecOption:
+		so.SetPassSec(v)
+	case MulticastAllOption:
+		so.SetMulticastAll(v)
+	default:
+		return &ErrUnknownProtocolOption{}
+	}
+	return nil
+}
+
+// GetBool gets the value of the boolean option opt. It provides a single
+// entry point for the getsockopt(2) implementations.
+//
+// Options which are unknown or do not apply to the socket's transport protocol
+// return ErrUnknownProtocolOption.
+func (so *SocketOptions) GetBool(opt SockOptBool) (bool, Error) {
+	switch opt {
+	case BroadcastOption:
+		return so.GetBroadcast(), nil
+	case PassCredOption:
+		return so.GetPassCred(), nil
+	case NoChecksumOption:
+		return so.GetNoChecksum(), nil
+	case ReuseAddressOption:
+		return so.GetReuseAddress(), nil
+	case ReusePortOption:
+		return so.GetReusePort(), nil
+	case KeepAliveOption:
+		return so.GetKeepAlive(), nil
+	case OutOfBandInlineOption:
+		return so.GetOutOfBandInline(), nil
+	case LockFilterOption:
+		return so.GetLockFilter(), nil
+	case MulticastLoopOption:
+		return so.GetMulticastLoop(), nil
+	case ReceiveTOSOption:
+		return so.GetReceiveTOS(), nil
+	case ReceiveTTLOption:
+		return so.GetReceiveTTL(), nil
+	case ReceiveHopLimitOption:
+		return so.GetReceiveHopLimit(), nil
+	case ReceiveTClassOption:
+		return so.GetReceiveTClass(), nil
+	case ReceivePacketInfoOption:
+		return so.GetReceivePacketInfo(), nil
+	case IPv6ReceivePacketInfoOption:
+		return so.GetIPv6ReceivePacketInfo(), nil
+	case HeaderIncludedOption:
+		return so.GetHeaderIncluded(), nil
+	case V6OnlyOption:
+		return so.GetV6Only(), nil
+	case ReceiveOriginalDstAddressOption:
+		return so.GetReceiveOriginalDstAddress(), nil
+	case IPv4RecvErrorOption:
+		return so.GetIPv4RecvError(), nil
+	case IPv6RecvErrorOption:
+		return so.GetIPv6RecvError(), nil
+	case SelectErrQueueOption:
+		return so.GetSelectErrQueue(), nil
+	case PassSecOption:
+		return so.GetPassSec(), nil
+	case MulticastAllOption:
+		return so.GetMulticastAll(), nil
+	case QuickAckOption, DelayOption, CorkOption:
+		if !so.SupportsTCPOptions() {
+			return false, &ErrUnknownProtocolOption{}
+		}
+		switch opt {
+		case QuickAckOption:
+			return so.GetQuickAck(), nil
+		case DelayOption:
+			return so.GetDelayOption(), nil
+		default:
+			return so.GetCorkOption(), nil
+		}
+	default:
+		return false, &ErrUnknownProtocolOption{}
+	}
+}
+
+// SockOptApplicability describes the sockets a socket option applies to.
+type SockOptApplicability int
+
+const (
+	// ApplicableToAllSockets indicates that the option applies to every
+	// socket.
+	ApplicableToAllSockets SockOptApplicability = iota
+
+	// ApplicableToTCPSockets indicates that the option only applies to sockets
+	// for which SupportsTCPOptions returns true.
+	ApplicableToTCPSockets
+)
+
+// sockOptBoolApplicability lists every SockOptBool handled by SetBool/GetBool.
+var sockOptBoolApplicability = map[SockOptBool]SockOptApplicability{
+	BroadcastOption:                 ApplicableToAllSockets,
+	PassCredOption:                  ApplicableToAllSockets,
+	NoChecksumOption:                ApplicableToAllSockets,
+	ReuseAddressOption:              ApplicableToAllSockets,
+	ReusePortOption:                 ApplicableToAllSockets,
+	KeepAliveOption:                 ApplicableToAllSockets,
+	OutOfBandInlineOption:           ApplicableToAllSockets,
+	LockFilterOption:                ApplicableToAllSockets,
+	MulticastLoopOption:             ApplicableToAllSockets,
+	ReceiveTOSOption:                ApplicableToAllSockets,
+	ReceiveTTLOption:                ApplicableToAllSockets,
+	ReceiveHopLimitOption:           ApplicableToAllSockets,
+	ReceiveTClassOption:             ApplicableToAllSockets,
+	ReceivePacketInfoOption:         ApplicableToAllSockets,
+	IPv6ReceivePacketInfoOption:     ApplicableToAllSockets,
+	HeaderIncludedOption:            ApplicableToAllSockets,
+	V6OnlyOption:                    ApplicableToAllSockets,
+	ReceiveOriginalDstAddressOption: ApplicableToAllSockets,
+	IPv4RecvErrorOption:             ApplicableToAllSockets,
+	IPv6RecvErrorOption:             ApplicableToAllSockets,
+	QuickAckOption:                  ApplicableToTCPSockets,
+	DelayOption:                     ApplicableToTCPSockets,
+	CorkOption:                      ApplicableToTCPSockets,
+	SelectErrQueueOption:            ApplicableToAllSockets,
+	PassSecOption:                   ApplicableToAllSockets,
+	MulticastAllOption:              ApplicableToAllSockets,
+}
+
+// sockOptIntApplicability lists every SocketOptionInt handled by
+// SetInt/GetInt.
+var sockOptIntApplicability = map[SocketOptionInt]SockOptApplicability{
+	SocketSendBufferSizeOption:         ApplicableToAllSockets,
+	SocketReceiveBufferSizeOption:      ApplicableToAllSockets,
+	SocketReceiveBufferSizeForceOption: ApplicableToAllSockets,
+	ReceiveLowWaterMarkOption:          ApplicableToAllSockets,
+	BindToDeviceOption:                 ApplicableToAllSockets,
+	MarkOption:                         ApplicableToAllSockets,
+	PriorityOption:                     ApplicableToAllSockets,
+	UnicastTTLOption:                   ApplicableToAllSockets,
+	UnicastHopLimitOption:              ApplicableToAllSockets,
+}
+
+// SockOptBoolApplicability returns the sockets opt applies to. Returns false
+// if opt is not handled by SetBool/GetBool.
+func SockOptBoolApplicability(opt SockOptBool) (SockOptApplicability, bool) {
+	a, ok := sockOptBoolApplicability[opt]
+	return a, ok
+}
+
+// SockOptIntApplicability returns the sockets opt applies to. Returns false
+// if opt is not handled by SetInt/GetInt.
+func SockOptIntApplicability(opt SocketOptionInt) (SockOptApplicability, bool) {
+	a, ok := sockOptIntApplicability[opt]
+	return a, ok
+}
+
+// GetMulticastInterface gets value for IP_MULTICAST_IF and IPV6_MULTICAST_IF
+// options.
+func (so *SocketOptions) GetMulticastInterface() MulticastInterfaceOption {
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	return so.multicastInterface
+}
+
+// SetMulticastInterface sets value for IP_MULTICAST_IF and IPV6_MULTICAST_IF
+// options. If v.NIC is zero, the interface is selected by v.InterfaceAddr
+// alone; if both are zero, the selection is cleared. Returns
+// ErrBadLocalAddress if v.NIC does not exist.
+func (so *SocketOptions) SetMulticastInterface(v MulticastInterfaceOption) Error {
+	if v.NIC != 0 && !so.handler.HasNIC(int32(v.NIC)) {
+		return &ErrBadLocalAddress{}
+	}
+
+	so.mu.Lock()
+	so.multicastInterface = v
+	so.mu.Unlock()
+
+	so.handler.OnSetMulticastInterface(v)
+	return nil
+}
+
 // GetLinger gets value for SO_LINGER option.
 func (so *SocketOptions) GetLinger() LingerOption {
 	so.mu.Lock()
@@ -552,6 +1595,88 @@ func (origin SockErrOrigin) IsICMPErr() bool {
 	return origin == SockExtErrorOriginICMP || origin == SockExtErrorOriginICMP6
 }
 
+func (origin SockErrOrigin) String() string {
+	switch origin {
+	case SockExtErrorOriginNone:
+		return "None"
+	case SockExtErrorOriginLocal:
+		return "Local"
+	case SockExtErrorOriginICMP:
+		return "ICMP"
+	case SockExtErrorOriginICMP6:
+		return "ICMP6"
+	default:
+		return fmt.Sprintf("SockErrOrigin(%d)", uint8(origin))
+	}
+}
+
+// DescribeSockErr returns a human readable description of a socket error with
+// the given origin, type and code, as reported by SockErrorCause. Common ICMP
+// destination unreachable and time exceeded errors are decoded; other errors
+// are described by their raw values.
+//
+// The ICMP type and code values are those of RFC 792 and RFC 4443. They are
+// spelled out here since this package cannot depend on the header package.
+func DescribeSockErr(origin SockErrOrigin, typ, code uint8) string {
+	var desc string
+	switch origin {
+	case SockExtErrorOriginICMP:
+		switch typ {
+		case 3:
+			desc = "destination unreachable"
+			switch code {
+			case 0:
+				desc += ": network unreachable"
+			case 1:
+				desc += ": host unreachable"
+			case 2:
+				desc += ": protocol unreachable"
+			case 3:
+				desc += ": port unreachable"
+			case 4:
+				desc += ": fragmentation needed"
+			}
+		case 11:
+			desc = "time exceeded"
+			switch code {
+			case 0:
+				desc += ": TTL exceeded in transit"
+			case 1:
+				desc += ": fragment reassembly time exceeded"
+			}
+		}
+	case SockExtErrorOriginICMP6:
+		switch typ {
+		case 1:
+			desc = "destination unreachable"
+			switch code {
+			case 0:
+				desc += ": no route to destination"
+			case 1:
+				desc += ": administratively prohibited"
+			case 3:
+				desc += ": address unreachable"
+			case 4:
+				desc += ": port unreachable"
+			}
+		case 2:
+			desc = "packet too big"
+		case 3:
+			desc = "time exceeded"
+			switch code {
+			case 0:
+				desc += ": hop limit exceeded in transit"
+			case 1:
+				desc += ": fragment reassembly time exceeded"
+			}
+		}
+	}
+	if desc == "" {
+		return fmt.Sprintf("%s error (type=%d, code=%d)", origin, typ, code)
+	}
+	return fmt.Sprintf("%s %s", origin, desc)
+}
+
 // SockErrorCause is the cause of a socket error.
 type SockErrorCause interface {
 	// Origin is the source of the error.
@@ -594,6 +1719,34 @@ func (l *LocalSockError) Info() uint32 {
 	return l.info
 }
 
+// MTUSockError is a local socket error reporting that a packet exceeded the
+// path MTU. The discovered MTU is carried in Info.
+//
+// +stateify savable
+type MTUSockError struct {
+	mtu uint32
+}
+
+// Origin implements SockErrorCause.
+func (*MTUSockError) Origin() SockErrOrigin {
+	return SockExtErrorOriginLocal
+}
+
+// Type implements SockErrorCause.
+func (*MTUSockError) Type() uint8 {
+	return 0
+}
+
+// Code implements SockErrorCause.
+func (*MTUSockError) Code() uint8 {
+	return 0
+}
+
+// Info implements SockErrorCause.
+func (m *MTUSockError) Info() uint32 {
+	return m.mtu
+}
+
 // SockError represents a queue entry in the per-socket error queue.
 //
 // +stateify savable
@@ -607,12 +1760,76 @@ type SockError struct {
 
 	// Payload is the errant packet's payload.
 	Payload *bufferv2.View
+	// OriginalPayloadLen is the length of the errant packet's payload before
+	// Payload was capped. QueueErr sets it to the size of Payload if it is
+	// zero.
+	OriginalPayloadLen int
 	// Dst is the original destination address of the errant packet.
 	Dst FullAddress
 	// Offender is the original sender address of the errant packet.
 	Offender FullAddress
 	// NetProto is the network protocol being used to transmit the packet.
 	NetProto NetworkProtocolNumber
+	// NICID is the ID of the NIC that received the ICMP error reporting the
+	// errant packet. It is zero for errors that originated locally.
+	NICID NICID
+	// Timestamp is the time at which the error was queued.
+	Timestamp time.Time `state:".(int64)"`
+}
+
+// Clone returns a copy of the error which owns its own reference to the
+// payload, so that the error can be handed to more than one consumer. Each
+// copy's payload must be released independently.
+func (s *SockError) Clone() *SockError {
+	c := &SockError{
+		Err:                s.Err,
+		Cause:              s.Cause,
+		OriginalPayloadLen: s.OriginalPayloadLen,
+		Dst:                s.Dst,
+		Offender:           s.Offender,
+		NetProto:           s.NetProto,
+		NICID:              s.NICID,
+		Timestamp:          s.Timestamp,
+	}
+	if s.Payload != nil {
+		c.Payload = s.Payload.Clone()
+	}
+	return c
+}
+
+// CapPayload caps the payload to at most n bytes. The untruncated length is
+// kept in OriginalPayloadLen so that consumers can still report it.
+func (s *SockError) CapPayload(n int) {
+	if s.OriginalPayloadLen == 0 {
+		s.OriginalPayloadLen = s.Payload.Size()
+	}
+	if s.Payload != nil {
+		s.Payload.CapLength(n)
+	}
+}
+
+// Equal returns true if s and other describe the same error: the same Err,
+// cause, destination, offender and network protocol. The payload and
+// timestamp are not compared.
+func (s *SockError) Equal(other *SockError) bool {
+	if s == nil || other == nil {
+		return s == other
+	}
+	if s.Err != other.Err {
+		return false
+	}
+	if (s.Cause == nil) != (other.Cause == nil) {
+		return false
+	}
+	if s.Cause != nil {
+		if s.Cause.Origin() != other.Cause.Origin() ||
+			s.Cause.Type() != other.Cause.Type() ||
+			s.Cause.Code() != other.Cause.Code() ||
+			s.Cause.Info() != other.Cause.Info() {
+			return false
+		}
+	}
+	return s.Dst == other.Dst && s.Offender == other.Offender && s.NetProto == other.NetProto
 }
 
 // pruneErrQueue resets the queue.
@@ -635,6 +1852,30 @@ func (so *SocketOptions) DequeueErr() *SockError {
 	return err
 }
 
+// DequeueErrForNetProto dequeues the first socket extended error in the error
+// queue which was caused by a packet of the network protocol proto and returns
+// it. Errors for other network protocols are left in place. Returns nil if no
+// such error is queued.
+func (so *SocketOptions) DequeueErrForNetProto(proto NetworkProtocolNumber) *SockError {
+	so.errQueueMu.Lock()
+	defer so.errQueueMu.Unlock()
+
+	for err := so.errQueue.Front(); err != nil; err = err.Next() {
+		if err.NetProto == proto {
+			so.errQueue.Remove(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// SelectErrQueueReady returns true if SO_SELECT_ERR_QUEUE is set and the error
+// queue is not empty, in which case the endpoint should report itself as
+// having urgent data (POLLPRI).
+func (so *SocketOptions) SelectErrQueueReady() bool {
+	return so.GetSelectErrQueue() && so.PeekErr() != nil
+}
+
 // PeekErr returns the error in the front of the error queue. Returns nil if
 // the error queue is empty.
 func (so *SocketOptions) PeekErr() *SockError {
@@ -643,29 +1884,252 @@ func (so *SocketOptions) PeekErr() *SockError {
 	return so.errQueue.Front()
 }
 
-// QueueErr inserts the error at the back of the error queue.
+// ExtendedError is the decoded form of a queued socket error, as delivered by
+// IP_RECVERR. The fields up to Info mirror Linux's struct sock_extended_err,
+// except that Err must still be translated to an errno by the caller.
+type ExtendedError struct {
+	// Err is the error caused by the errant packet.
+	Err Error
+	// Origin is the source of the error.
+	Origin SockErrOrigin
+	// Type is the origin specific type of error.
+	Type uint8
+	// Code is the origin and type specific error code.
+	Code uint8
+	// Info is any extra information about the error.
+	Info uint32
+
+	// Offender is the original sender address of the errant packet.
+	Offender FullAddress
+	// Dst is the original destination address of the errant packet.
+	Dst FullAddress
+	// NetProto is the network protocol being used to transmit the packet.
+	NetProto NetworkProtocolNumber
+	// NICID is the ID of the NIC that received the ICMP error, the equivalent
+	// of Linux's ee_ifindex reporting. It is zero for local errors.
+	NICID NICID
+	// Payload is the errant packet's payload. The caller takes ownership of
+	// it.
+	Payload *bufferv2.View
+	// OriginalPayloadLen is the length of the errant packet's payload before
+	// it was capped.
+	OriginalPayloadLen int
+}
+
+// NextExtendedError dequeues the socket error at the front of the error queue
+// and returns its decoded form. Returns false if the queue is empty.
+func (so *SocketOptions) NextExtendedError() (ExtendedError, bool) {
+	sockErr := so.DequeueErr()
+	if sockErr == nil {
+		return ExtendedError{}, false
+	}
+	return ExtendedError{
+		Err:      sockErr.Err,
+		Origin:   sockErr.Cause.Origin(),
+		Type:     sockErr.Cause.Type(),
+		Code:     sockErr.Cause.Code(),
+		Info:     sockErr.Cause.Info(),
+		Offender: sockErr.Offender,
+		Dst:      sockErr.Dst,
+		NetProto: sockErr.NetProto,
+		NICID:    sockErr.NICID,
+		Payload:  sockErr.Payload,
+
+		OriginalPayloadLen: sockErr.OriginalPayloadLen,
+	}, true
+}
+
+// QueueErr inserts the error at the back of the error queue. If the error
+// doesn't carry a timestamp, it is stamped with the stack's current time. The
+// handler is notified if the queue was previously empty. Errors without a
+// Cause are dropped and their payload released, as readers of the queue
+// report the cause.
 //
 // Preconditions: so.GetIPv4RecvError() or so.GetIPv6RecvError() is true.
 func (so *SocketOptions) QueueErr(err *SockError) {
+	so.queueErr(err, false /* coalesce */)
+}
+
+// queueErr implements QueueErr. If coalesce is true and err is equal to the
+// error at the back of the queue, err is dropped and its payload released.
+func (so *SocketOptions) queueErr(err *SockError, coalesce bool) {
+	if err.Cause == nil {
+		if err.Payload != nil {
+			err.Payload.Release()
+		}
+		return
+	}
+	if err.Timestamp.IsZero() {
+		err.Timestamp = so.stackHandler.Clock().Now()
+	}
+	if err.OriginalPayloadLen == 0 {
+		err.OriginalPayloadLen = err.Payload.Size()
+	}
 	so.errQueueMu.Lock()
-	defer so.errQueueMu.Unlock()
+	if coalesce && err.Equal(so.errQueue.Back()) {
+		so.errQueueMu.Unlock()
+		if err.Payload != nil {
+			err.Payload.Release()
+		}
+		return
+	}
+	wasEmpty := so.errQueue.Empty()
 	so.errQueue.PushBack(err)
+	so.errQueueMu.Unlock()
+
+	if wasEmpty {
+		so.handler.OnErrQueueNonEmpty()
+	}
 }
 
-// QueueLocalErr queues a local error onto the local queue.
+// QueueLocalErr queues a local error onto the local queue. If coalescing is
+// enabled, an error equal to the one at the back of the queue is dropped.
 func (so *SocketOptions) QueueLocalErr(err Error, net NetworkProtocolNumber, info uint32, dst FullAddress, payload *bufferv2.View) {
-	so.QueueErr(&SockError{
+	so.queueErr(&SockError{
 		Err:      err,
 		Cause:    &LocalSockError{info: info},
 		Payload:  payload,
 		Dst:      dst,
 		NetProto: net,
+	}, so.GetCoalesceLocalErrors())
+}
+
+// GetCoalesceLocalErrors returns whether identical consecutive local errors
+// are coalesced in the error queue.
+func (so *SocketOptions) GetCoalesceLocalErrors() bool {
+	return so.coalesceLocalErrsEnabled.Load() != 0
+}
+
+// SetCoalesceLocalErrors sets whether identical consecutive local errors are
+// coalesced in the error queue.
+func (so *SocketOptions) SetCoalesceLocalErrors(v bool) {
+	storeAtomicBool(&so.coalesceLocalErrsEnabled, v)
+}
+
+// QueueICMPErrWithOffender queues an error caused by a received ICMP error
+// onto the error queue. offender is the address of the socket that sent the
+// errant packet, dst is the address it was sent to and nicID is the NIC the
+// ICMP error was received on.
+//
+// Errors whose cause does not originate from ICMP or ICMPv6 are dropped.
+func (so *SocketOptions) QueueICMPErrWithOffender(err Error, net NetworkProtocolNumber, nicID NICID, cause SockErrorCause, dst, offender FullAddress, payload *bufferv2.View) {
+	if !cause.Origin().IsICMPErr() {
+		return
+	}
+	so.QueueErr(&SockError{
+		Err:      err,
+		Cause:    cause,
+		Payload:  payload,
+		Dst:      dst,
+		Offender: offender,
+		NetProto: net,
+		NICID:    nicID,
+	})
+}
+
+// QueueMTUErr records mtu as the latest path MTU and queues an EMSGSIZE error
+// carrying it onto the error queue. Like QueueLocalErr, callers are expected
+// to check that IP_RECVERR/IPV6_RECVERR is enabled first.
+func (so *SocketOptions) QueueMTUErr(net NetworkProtocolNumber, mtu uint32, dst FullAddress, payload *bufferv2.View) {
+	so.lastPathMTU.Store(mtu)
+	so.QueueErr(&SockError{
+		Err:      &ErrMessageTooLong{},
+		Cause:    &MTUSockError{mtu: mtu},
+		Payload:  payload,
+		Dst:      dst,
+		NetProto: net,
 	})
 }
 
+// GetPathMTU gets value for IP_MTU option.
+func (so *SocketOptions) GetPathMTU() (uint32, Error) {
+	return so.handler.PathMTU()
+}
+
+// GetPeerSec gets value for SO_PEERSEC option.
+func (so *SocketOptions) GetPeerSec() ([]byte, Error) {
+	return so.handler.PeerSec()
+}
+
+// LastPathMTU returns the path MTU most recently reported through
+// QueueMTUErr, or 0 if none has been reported.
+func (so *SocketOptions) LastPathMTU() uint32 {
+	return so.lastPathMTU.Load()
+}
+
+// SockFilter is a classic BPF instruction, equivalent to Linux's struct
+// sock_filter. It mirrors linux.BPFInstruction, which netstack cannot depend
+// on.
+type SockFilter struct {
+	// OpCode is the operation to execute.
+	OpCode uint16
+
+	// JumpIfTrue is the number of instructions to skip if OpCode is a
+	// conditional instruction and the condition is true.
+	JumpIfTrue uint8
+
+	// JumpIfFalse is the number of instructions to skip if OpCode is a
+	// conditional instruction and the condition is false.
+	JumpIfFalse uint8
+
+	// K is a constant parameter. The meaning depends on the value of OpCode.
+	K uint32
+}
+
+// MaxSockFilterLen is the maximum number of instructions in a socket filter
+// program. It is equal to Linux's BPF_MAXINSNS.
+const MaxSockFilterLen = 4096
+
+// SetAttachFilter attaches the classic BPF program prog to the socket, as with
+// SO_ATTACH_FILTER.
+func (so *SocketOptions) SetAttachFilter(prog []SockFilter) Error {
+	if so.GetLockFilter() {
+		return &ErrNotPermitted{}
+	}
+	if len(prog) == 0 || len(prog) > MaxSockFilterLen {
+		return &ErrInvalidOptionValue{}
+	}
+	return so.handler.AttachFilter(prog)
+}
+
+// SetDetachFilter detaches the socket filter previously attached with
+// SetAttachFilter, as with SO_DETACH_FILTER.
+func (so *SocketOptions) SetDetachFilter() Error {
+	if so.GetLockFilter() {
+		return &ErrNotPermitted{}
+	}
+	return so.handler.DetachFilter()
+}
+
+// GetLockFilter gets value for SO_LOCK_FILTER option.
+func (so *SocketOptions) GetLockFilter() bool {
+	return so.lockFilterEnabled.Load() != 0
+}
+
+// SetLockFilter sets value for SO_LOCK_FILTER option. Once the filter is
+// locked it cannot be unlocked.
+func (so *SocketOptions) SetLockFilter(v bool) Error {
+	if !v && so.GetLockFilter() {
+		return &ErrNotPermitted{}
+	}
+	if v {
+		storeAtomicBool(&so.lockFilterEnabled, v)
+	}
+	return nil
+}
+
 // GetBindToDevice gets value for SO_BINDTODEVICE option.
+//
+// A binding to a NIC that has since been removed is dropped here, which covers
+// endpoints that were not registered with the stack when the NIC was removed
+// and so were not passed to OnNICRemoved.
 func (so *SocketOptions) GetBindToDevice() int32 {
-	return so.bindToDevice.Load()
+	id := so.bindToDevice.Load()
+	if id != 0 && !so.handler.HasNIC(id) {
+		so.bindToDevice.CompareAndSwap(id, 0)
+		return 0
+	}
+	return id
 }
 
 // SetBindToDevice sets value for SO_BINDTODEVICE option. If bindToDevice is
@@ -679,7 +2143,44 @@ func (so *SocketOptions) SetBindToDevice(bindToDevice int32) Error {
 	return nil
 }
 
-// GetSendBufferSize gets value for SO_SNDBUF option.
+// SetBindToDeviceByName sets value for SO_BINDTODEVICE option using the name
+// of the device. If name is empty, the socket device binding is removed.
+func (so *SocketOptions) SetBindToDeviceByName(name string) Error {
+	if name == "" {
+		return so.SetBindToDevice(0)
+	}
+	id, ok := so.handler.NICIDForName(name)
+	if !ok {
+		return &ErrUnknownDevice{}
+	}
+	return so.SetBindToDevice(id)
+}
+
+// GetBindToDeviceName gets the name of the device set by SO_BINDTODEVICE. An
+// empty name is returned if the socket is not bound to a device or the device
+// no longer exists.
+func (so *SocketOptions) GetBindToDeviceName() string {
+	id := so.GetBindToDevice()
+	if id == 0 {
+		return ""
+	}
+	name, _ := so.handler.NICName(id)
+	return name
+}
+
+// OnNICRemoved is called by the stack when the NIC id is removed. If the socket
+// is bound to it with SO_BINDTODEVICE, the binding is removed and the handler
+// is notified.
+func (so *SocketOptions) OnNICRemoved(id int32) {
+	if id == 0 || !so.bindToDevice.CompareAndSwap(id, 0) {
+		return
+	}
+	so.handler.OnBoundNICRemoved(id)
+}
+
+// GetSendBufferSize gets value for SO_SNDBUF option. Like Linux, sizes
+// requested with setsockopt(2) are stored multiplied by BufferOverheadFactor,
+// so this is the size getsockopt(2) reports.
 func (so *SocketOptions) GetSendBufferSize() int64 {
 	return so.sendBufferSize.Load()
 }
@@ -691,19 +2192,83 @@ func (so *SocketOptions) SendBufferLimits() (min, max int64) {
 	return int64(limits.Min), int64(limits.Max)
 }
 
+// SendBufferAutoTuneDisabled returns true if the send buffer size was set
+// explicitly and must not be autotuned.
+func (so *SocketOptions) SendBufferAutoTuneDisabled() bool {
+	return so.sendBufAutoTuneDisabled.Load() != 0
+}
+
+// GetSendBufferDefault returns the default send buffer size reported by the
+// send buffer limits.
+func (so *SocketOptions) GetSendBufferDefault() int64 {
+	return int64(so.getSendBufferLimits(so.stackHandler).Default)
+}
+
+// MaxSocketBufferSize is the absolute ceiling for socket buffer sizes. Sizes
+// above it are clamped regardless of the configured limits, so that endpoints
+// can scale buffer sizes (e.g. for window scaling) without overflowing. It
+// matches Linux, which stores buffer sizes as an int.
+const MaxSocketBufferSize = math.MaxInt32
+
+// BufferOverheadFactor is the factor by which buffer sizes requested with
+// SO_SNDBUF and SO_RCVBUF are multiplied before they are stored. Like Linux,
+// the requested size is doubled to leave room for bookkeeping overhead, and
+// getsockopt(2) reports the doubled size.
+const BufferOverheadFactor = 2
+
+// clampBufferSize clamps v to MaxSocketBufferSize.
+func clampBufferSize(v int64) int64 {
+	if v > MaxSocketBufferSize {
+		return MaxSocketBufferSize
+	}
+	return v
+}
+
 // SetSendBufferSize sets value for SO_SNDBUF option. notify indicates if the
-// stack handler should be invoked to set the send buffer size.
+// stack handler should be invoked to set the send buffer size. Setting the
+// size with notify pins it and disables send buffer autotuning. The size is
+// clamped to MaxSocketBufferSize.
 func (so *SocketOptions) SetSendBufferSize(sendBufferSize int64, notify bool) {
+	sendBufferSize = clampBufferSize(sendBufferSize)
 	if notify {
+		if so.sendBufAutoTuneDisabled.CompareAndSwap(0, 1) {
+			so.handler.OnSendBufferAutoTuneDisabled()
+		}
 		sendBufferSize = so.handler.OnSetSendBufferSize(sendBufferSize)
 	}
 	so.sendBufferSize.Store(sendBufferSize)
+	so.updateSendBufferFull()
 	if notify {
 		so.handler.WakeupWriters()
 	}
 }
 
-// GetReceiveBufferSize gets value for SO_RCVBUF option.
+// UpdateSendBufferUsage records that used bytes of the send buffer are in use.
+// Endpoints call it whenever their send buffer accounting changes. The
+// handler is notified through OnSendBufferFullChanged if the buffer became
+// full or stopped being full.
+func (so *SocketOptions) UpdateSendBufferUsage(used int64) {
+	so.sendBufferUsed.Store(used)
+	so.updateSendBufferFull()
+}
+
+// SendBufferFull returns true if the send buffer was full when its usage or
+// size last changed.
+func (so *SocketOptions) SendBufferFull() bool {
+	return so.sendBufferFull.Load()
+}
+
+// updateSendBufferFull notifies the handler if the send buffer's full state
+// changed.
+func (so *SocketOptions) updateSendBufferFull() {
+	full := so.sendBufferUsed.Load() >= so.GetSendBufferSize()
+	if so.sendBufferFull.Swap(full) != full {
+		so.handler.OnSendBufferFullChanged(full)
+	}
+}
+
+// GetReceiveBufferSize gets value for SO_RCVBUF option. See
+// GetSendBufferSize.
 func (so *SocketOptions) GetReceiveBufferSize() int64 {
 	return so.receiveBufferSize.Load()
 }
@@ -715,11 +2280,29 @@ func (so *SocketOptions) ReceiveBufferLimits() (min, max int64) {
 	return int64(limits.Min), int64(limits.Max)
 }
 
+// ReceiveBufferAutoTuneDisabled returns true if the receive buffer size was
+// set explicitly and must not be autotuned.
+func (so *SocketOptions) ReceiveBufferAutoTuneDisabled() bool {
+	return so.rcvBufAutoTuneDisabled.Load() != 0
+}
+
+// GetReceiveBufferDefault returns the default receive buffer size reported by
+// the receive buffer limits.
+func (so *SocketOptions) GetReceiveBufferDefault() int64 {
+	return int64(so.getReceiveBufferLimits(so.stackHandler).Default)
+}
+
 // SetReceiveBufferSize sets the value of the SO_RCVBUF option, optionally
-// notifying the owning endpoint.
+// notifying the owning endpoint. Setting the size with notify pins it and
+// disables receive buffer autotuning. The size is clamped to
+// MaxSocketBufferSize.
 func (so *SocketOptions) SetReceiveBufferSize(receiveBufferSize int64, notify bool) {
+	receiveBufferSize = clampBufferSize(receiveBufferSize)
 	var postSet func()
 	if notify {
+		if so.rcvBufAutoTuneDisabled.CompareAndSwap(0, 1) {
+			so.handler.OnReceiveBufferAutoTuneDisabled()
+		}
 		oldSz := so.receiveBufferSize.Load()
 		receiveBufferSize, postSet = so.handler.OnSetReceiveBufferSize(receiveBufferSize, oldSz)
 	}
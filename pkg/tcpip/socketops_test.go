@@ -0,0 +1,2090 @@
+// Copyright 2022 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcpip
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/bufferv2"
+	"gvisor.dev/gvisor/pkg/refs"
+)
+
+const (
+	testUDPProtocolNumber TransportProtocolNumber = 17
+	testNICID                                     = 1
+	testNICName                                   = "eth0"
+)
+
+// testHandler is a SocketOptionsHandler which records the options it was
+// notified about.
+type testHandler struct {
+	DefaultSocketOptionsHandler
+
+	cork                 bool
+	quickAckSets         []bool
+	sendAutoTuneOffHits  int
+	rcvAutoTuneOffHits   int
+	selectErrQueueSets   []bool
+	passSecSets          []bool
+	multicastAllSets     []bool
+	peerSec              []byte
+	filter               []SockFilter
+	errQueueNonEmptyHits int
+	removedBoundNICs     []int32
+	nicRemoved           bool
+	pathMTU              uint32
+	bound                bool
+	lastErr              Error
+	sendBufSizes         []int64
+	rcvBufSizes          []int64
+	windowUpdates        int
+	sendBufFullChanges   []bool
+	multicastIfaces      []MulticastInterfaceOption
+	sockOptInts          map[SockOptInt]int
+}
+
+// OnCorkOptionSet implements SocketOptionsHandler.OnCorkOptionSet.
+func (h *testHandler) OnCorkOptionSet(v bool) {
+	h.cork = v
+}
+
+// SetSockOptInt implements SocketOptionsHandler.SetSockOptInt.
+func (h *testHandler) SetSockOptInt(opt SockOptInt, v int) Error {
+	if h.sockOptInts == nil {
+		h.sockOptInts = make(map[SockOptInt]int)
+	}
+	h.sockOptInts[opt] = v
+	return nil
+}
+
+// GetSockOptInt implements SocketOptionsHandler.GetSockOptInt.
+func (h *testHandler) GetSockOptInt(opt SockOptInt) (int, Error) {
+	return h.sockOptInts[opt], nil
+}
+
+// OnQuickAckSet implements SocketOptionsHandler.OnQuickAckSet.
+func (h *testHandler) OnQuickAckSet(v bool) {
+	h.quickAckSets = append(h.quickAckSets, v)
+}
+
+// OnSendBufferAutoTuneDisabled implements
+// SocketOptionsHandler.OnSendBufferAutoTuneDisabled.
+func (h *testHandler) OnSendBufferAutoTuneDisabled() {
+	h.sendAutoTuneOffHits++
+}
+
+// OnReceiveBufferAutoTuneDisabled implements
+// SocketOptionsHandler.OnReceiveBufferAutoTuneDisabled.
+func (h *testHandler) OnReceiveBufferAutoTuneDisabled() {
+	h.rcvAutoTuneOffHits++
+}
+
+// OnSelectErrQueueSet implements SocketOptionsHandler.OnSelectErrQueueSet.
+func (h *testHandler) OnSelectErrQueueSet(v bool) {
+	h.selectErrQueueSets = append(h.selectErrQueueSets, v)
+}
+
+// OnSendBufferFullChanged implements
+// SocketOptionsHandler.OnSendBufferFullChanged.
+func (h *testHandler) OnSendBufferFullChanged(full bool) {
+	h.sendBufFullChanges = append(h.sendBufFullChanges, full)
+}
+
+// OnPassSecSet implements SocketOptionsHandler.OnPassSecSet.
+func (h *testHandler) OnPassSecSet(v bool) {
+	h.passSecSets = append(h.passSecSets, v)
+}
+
+// OnMulticastAllSet implements SocketOptionsHandler.OnMulticastAllSet.
+func (h *testHandler) OnMulticastAllSet(v bool) {
+	h.multicastAllSets = append(h.multicastAllSets, v)
+}
+
+// OnSetSendBufferSize implements SocketOptionsHandler.OnSetSendBufferSize.
+func (h *testHandler) OnSetSendBufferSize(v int64) int64 {
+	h.sendBufSizes = append(h.sendBufSizes, v)
+	return v
+}
+
+// OnSetReceiveBufferSize implements SocketOptionsHandler.OnSetReceiveBufferSize.
+// Like the TCP endpoint, it only announces a window update when the buffer
+// grows.
+func (h *testHandler) OnSetReceiveBufferSize(v, oldSz int64) (int64, func()) {
+	h.rcvBufSizes = append(h.rcvBufSizes, v)
+	if v <= oldSz {
+		return v, nil
+	}
+	return v, func() { h.windowUpdates++ }
+}
+
+// OnSetMulticastInterface implements
+// SocketOptionsHandler.OnSetMulticastInterface.
+func (h *testHandler) OnSetMulticastInterface(v MulticastInterfaceOption) {
+	h.multicastIfaces = append(h.multicastIfaces, v)
+}
+
+// LastError implements SocketOptionsHandler.LastError.
+func (h *testHandler) LastError() Error {
+	return h.lastErr
+}
+
+// UpdateLastError implements SocketOptionsHandler.UpdateLastError.
+func (h *testHandler) UpdateLastError(err Error) {
+	h.lastErr = err
+}
+
+// InInitialState implements SocketOptionsHandler.InInitialState.
+func (h *testHandler) InInitialState() bool {
+	return !h.bound
+}
+
+// HasNIC implements SocketOptionsHandler.HasNIC.
+func (h *testHandler) HasNIC(v int32) bool {
+	return v == testNICID && !h.nicRemoved
+}
+
+// AttachFilter implements SocketOptionsHandler.AttachFilter.
+func (h *testHandler) AttachFilter(prog []SockFilter) Error {
+	h.filter = prog
+	return nil
+}
+
+// DetachFilter implements SocketOptionsHandler.DetachFilter.
+func (h *testHandler) DetachFilter() Error {
+	h.filter = nil
+	return nil
+}
+
+// OnErrQueueNonEmpty implements SocketOptionsHandler.OnErrQueueNonEmpty.
+func (h *testHandler) OnErrQueueNonEmpty() {
+	h.errQueueNonEmptyHits++
+}
+
+// OnBoundNICRemoved implements SocketOptionsHandler.OnBoundNICRemoved.
+func (h *testHandler) OnBoundNICRemoved(v int32) {
+	h.removedBoundNICs = append(h.removedBoundNICs, v)
+}
+
+// PathMTU implements SocketOptionsHandler.PathMTU. A zero pathMTU means the
+// endpoint is not connected.
+func (h *testHandler) PathMTU() (uint32, Error) {
+	if h.pathMTU == 0 {
+		return 0, &ErrNotConnected{}
+	}
+	return h.pathMTU, nil
+}
+
+// PeerSec implements SocketOptionsHandler.PeerSec.
+func (h *testHandler) PeerSec() ([]byte, Error) {
+	return h.peerSec, nil
+}
+
+// NICIDForName implements SocketOptionsHandler.NICIDForName.
+func (*testHandler) NICIDForName(name string) (int32, bool) {
+	return testNICID, name == testNICName
+}
+
+// NICName implements SocketOptionsHandler.NICName.
+func (*testHandler) NICName(v int32) (string, bool) {
+	return testNICName, v == testNICID
+}
+
+// testStackHandler is a StackHandler which only supports the buffer size
+// options and, if set, the TCP keepalive defaults.
+type testStackHandler struct {
+	sendBufferSize    SendBufferSizeOption
+	receiveBufferSize ReceiveBufferSizeOption
+	keepAlive         *TCPKeepaliveDefaultsOption
+	clock             testClock
+}
+
+func newTestStackHandler() *testStackHandler {
+	return &testStackHandler{
+		sendBufferSize: SendBufferSizeOption{
+			Min:     4096,
+			Default: 16384,
+			Max:     4 << 20,
+		},
+		receiveBufferSize: ReceiveBufferSizeOption{
+			Min:     4096,
+			Default: 131072,
+			Max:     4 << 20,
+		},
+	}
+}
+
+// Option implements StackHandler.Option.
+func (s *testStackHandler) Option(option any) Error {
+	switch v := option.(type) {
+	case *SendBufferSizeOption:
+		*v = s.sendBufferSize
+		return nil
+	case *ReceiveBufferSizeOption:
+		*v = s.receiveBufferSize
+		return nil
+	default:
+		return &ErrUnknownProtocolOption{}
+	}
+}
+
+// TransportProtocolOption implements StackHandler.TransportProtocolOption.
+func (s *testStackHandler) TransportProtocolOption(proto TransportProtocolNumber, option GettableTransportProtocolOption) Error {
+	switch v := option.(type) {
+	case *TCPKeepaliveDefaultsOption:
+		if proto != tcpProtocolNumber || s.keepAlive == nil {
+			return &ErrUnknownProtocolOption{}
+		}
+		*v = *s.keepAlive
+		return nil
+	default:
+		return &ErrUnknownProtocolOption{}
+	}
+}
+
+// Clock implements StackHandler.Clock.
+func (s *testStackHandler) Clock() Clock {
+	return &s.clock
+}
+
+// testClock is a Clock whose time only changes when set explicitly.
+type testClock struct {
+	now time.Time
+}
+
+// Now implements Clock.Now.
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// NowMonotonic implements Clock.NowMonotonic.
+func (c *testClock) NowMonotonic() MonotonicTime {
+	return MonotonicTime{nanoseconds: c.now.UnixNano()}
+}
+
+// AfterFunc implements Clock.AfterFunc.
+func (*testClock) AfterFunc(time.Duration, func()) Timer {
+	panic("unimplemented")
+}
+
+func newTestSocketOptions(handler SocketOptionsHandler, proto TransportProtocolNumber) *SocketOptions {
+	return newTestSocketOptionsWithStack(handler, newTestStackHandler(), proto)
+}
+
+func newTestSocketOptionsWithStack(handler SocketOptionsHandler, stack StackHandler, proto TransportProtocolNumber) *SocketOptions {
+	var so SocketOptions
+	so.InitHandler(handler, stack, GetStackSendBufferLimits, GetStackReceiveBufferLimits)
+	so.SetTransportProtocol(proto)
+	return &so
+}
+
+func TestSetCorkOptionApplicability(t *testing.T) {
+	tests := []struct {
+		name    string
+		proto   TransportProtocolNumber
+		wantErr Error
+	}{
+		{
+			name:    "TCP",
+			proto:   tcpProtocolNumber,
+			wantErr: nil,
+		},
+		{
+			name:    "Unknown protocol",
+			proto:   0,
+			wantErr: &ErrUnknownProtocolOption{},
+		},
+		{
+			name:    "UDP",
+			proto:   testUDPProtocolNumber,
+			wantErr: &ErrUnknownProtocolOption{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handler testHandler
+			so := newTestSocketOptions(&handler, test.proto)
+			if diff := cmp.Diff(test.wantErr, so.SetCorkOption(true)); diff != "" {
+				t.Fatalf("so.SetCorkOption(true) mismatch (-want +got):\n%s", diff)
+			}
+			want := test.wantErr == nil
+			if got := so.GetCorkOption(); got != want {
+				t.Errorf("got so.GetCorkOption() = %t, want = %t", got, want)
+			}
+			if handler.cork != want {
+				t.Errorf("got handler.cork = %t, want = %t", handler.cork, want)
+			}
+		})
+	}
+}
+
+func TestReceiveCmsgMask(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*SocketOptions, bool)
+		want CmsgMask
+	}{
+		{
+			name: "TOS",
+			set:  (*SocketOptions).SetReceiveTOS,
+			want: CmsgMaskTOS,
+		},
+		{
+			name: "TTL",
+			set:  (*SocketOptions).SetReceiveTTL,
+			want: CmsgMaskTTL,
+		},
+		{
+			name: "HopLimit",
+			set:  (*SocketOptions).SetReceiveHopLimit,
+			want: CmsgMaskHopLimit,
+		},
+		{
+			name: "TClass",
+			set:  (*SocketOptions).SetReceiveTClass,
+			want: CmsgMaskTClass,
+		},
+		{
+			name: "PacketInfo",
+			set:  (*SocketOptions).SetReceivePacketInfo,
+			want: CmsgMaskPacketInfo,
+		},
+		{
+			name: "IPv6PacketInfo",
+			set:  (*SocketOptions).SetIPv6ReceivePacketInfo,
+			want: CmsgMaskIPv6PacketInfo,
+		},
+		{
+			name: "OriginalDstAddress",
+			set:  (*SocketOptions).SetReceiveOriginalDstAddress,
+			want: CmsgMaskOriginalDstAddress,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			so := newTestSocketOptions(&testHandler{}, 0)
+			if got := so.ReceiveCmsgMask(); got != 0 {
+				t.Fatalf("got so.ReceiveCmsgMask() = %#x, want = 0", got)
+			}
+			test.set(so, true)
+			if got := so.ReceiveCmsgMask(); got != test.want {
+				t.Errorf("got so.ReceiveCmsgMask() = %#x, want = %#x", got, test.want)
+			}
+			test.set(so, false)
+			if got := so.ReceiveCmsgMask(); got != 0 {
+				t.Errorf("got so.ReceiveCmsgMask() = %#x, want = 0", got)
+			}
+		})
+	}
+}
+
+func TestNewSocketOptions(t *testing.T) {
+	var handler testHandler
+	stack := newTestStackHandler()
+	got := NewSocketOptions(&handler, stack, GetStackSendBufferLimits, GetStackReceiveBufferLimits)
+
+	var want SocketOptions
+	want.InitHandler(&handler, stack, GetStackSendBufferLimits, GetStackReceiveBufferLimits)
+	want.SetSendBufferSize(int64(stack.sendBufferSize.Default), false /* notify */)
+	want.SetReceiveBufferSize(int64(stack.receiveBufferSize.Default), false /* notify */)
+
+	if got, want := got.GetSendBufferSize(), want.GetSendBufferSize(); got != want {
+		t.Errorf("got GetSendBufferSize() = %d, want = %d", got, want)
+	}
+	if got, want := got.GetReceiveBufferSize(), want.GetReceiveBufferSize(); got != want {
+		t.Errorf("got GetReceiveBufferSize() = %d, want = %d", got, want)
+	}
+	if got, want := got.GetOutOfBandInline(), want.GetOutOfBandInline(); got != want {
+		t.Errorf("got GetOutOfBandInline() = %t, want = %t", got, want)
+	}
+	if got, want := got.GetRcvlowat(), want.GetRcvlowat(); got != want {
+		t.Errorf("got GetRcvlowat() = %d, want = %d", got, want)
+	}
+	if got.handler != want.handler || got.stackHandler != want.stackHandler {
+		t.Errorf("got handlers = (%p, %p), want = (%p, %p)", got.handler, got.stackHandler, want.handler, want.stackHandler)
+	}
+}
+
+func TestBufferDefaults(t *testing.T) {
+	stack := newTestStackHandler()
+	so := newTestSocketOptionsWithStack(&testHandler{}, stack, 0)
+
+	if got, want := so.GetSendBufferDefault(), int64(GetStackSendBufferLimits(stack).Default); got != want {
+		t.Errorf("got so.GetSendBufferDefault() = %d, want = %d", got, want)
+	}
+	if got, want := so.GetReceiveBufferDefault(), int64(GetStackReceiveBufferLimits(stack).Default); got != want {
+		t.Errorf("got so.GetReceiveBufferDefault() = %d, want = %d", got, want)
+	}
+}
+
+func TestConcurrentOptionAccess(t *testing.T) {
+	const iterations = 1000
+
+	// testHandler records notifications without synchronization, so use a
+	// stateless handler which is safe to share between goroutines.
+	so := newTestSocketOptions(&DefaultSocketOptionsHandler{}, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				so.SetLinger(LingerOption{Enabled: j%2 == 0, Timeout: time.Duration(i) * time.Second})
+				_ = so.GetLinger()
+				so.SetSendBufferSize(int64(4096+j), true /* notify */)
+				_ = so.GetSendBufferSize()
+				so.SetReceiveBufferSize(int64(4096+j), true /* notify */)
+				_ = so.GetReceiveBufferSize()
+				if err := so.SetRcvlowat(int32(j)); err != nil {
+					t.Errorf("so.SetRcvlowat(%d): %s", j, err)
+				}
+				_ = so.GetRcvlowat()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got, want := so.GetSendBufferSize(), int64(4096+iterations-1); got != want {
+		t.Errorf("got so.GetSendBufferSize() = %d, want = %d", got, want)
+	}
+	if got, want := so.GetReceiveBufferSize(), int64(4096+iterations-1); got != want {
+		t.Errorf("got so.GetReceiveBufferSize() = %d, want = %d", got, want)
+	}
+}
+
+func TestQueueErrTimestamp(t *testing.T) {
+	stack := newTestStackHandler()
+	so := newTestSocketOptionsWithStack(&testHandler{}, stack, 0)
+	so.SetIPv4RecvError(true)
+
+	first := time.Unix(1000, 0)
+	stack.clock.now = first
+	so.QueueLocalErr(&ErrMessageTooLong{}, 0, 0, FullAddress{}, nil)
+
+	second := first.Add(time.Second)
+	stack.clock.now = second
+	so.QueueLocalErr(&ErrMessageTooLong{}, 0, 0, FullAddress{}, nil)
+
+	for _, want := range []time.Time{first, second} {
+		sockErr := so.DequeueErr()
+		if sockErr == nil {
+			t.Fatalf("got so.DequeueErr() = nil, want error queued at %s", want)
+		}
+		if !sockErr.Timestamp.Equal(want) {
+			t.Errorf("got sockErr.Timestamp = %s, want = %s", sockErr.Timestamp, want)
+		}
+	}
+}
+
+func TestSetAttachFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		prog    []SockFilter
+		wantErr Error
+	}{
+		{
+			name:    "Valid",
+			prog:    []SockFilter{{OpCode: 0x06 /* BPF_RET|BPF_K */, K: 0xffff}},
+			wantErr: nil,
+		},
+		{
+			name:    "Empty",
+			prog:    nil,
+			wantErr: &ErrInvalidOptionValue{},
+		},
+		{
+			name:    "Over-length",
+			prog:    make([]SockFilter, MaxSockFilterLen+1),
+			wantErr: &ErrInvalidOptionValue{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handler testHandler
+			so := newTestSocketOptions(&handler, 0)
+			if diff := cmp.Diff(test.wantErr, so.SetAttachFilter(test.prog)); diff != "" {
+				t.Fatalf("so.SetAttachFilter(_) mismatch (-want +got):\n%s", diff)
+			}
+			wantFilter := test.prog
+			if test.wantErr != nil {
+				wantFilter = nil
+			}
+			if diff := cmp.Diff(wantFilter, handler.filter); diff != "" {
+				t.Errorf("attached filter mismatch (-want +got):\n%s", diff)
+			}
+			if err := so.SetDetachFilter(); err != nil {
+				t.Fatalf("so.SetDetachFilter(): %s", err)
+			}
+			if handler.filter != nil {
+				t.Errorf("got handler.filter = %v after detach, want = nil", handler.filter)
+			}
+		})
+	}
+}
+
+func TestDefaultHandlerFilter(t *testing.T) {
+	so := newTestSocketOptions(&DefaultSocketOptionsHandler{}, 0)
+	prog := []SockFilter{{OpCode: 0x06 /* BPF_RET|BPF_K */}}
+	if diff := cmp.Diff(&ErrUnknownProtocolOption{}, so.SetAttachFilter(prog)); diff != "" {
+		t.Errorf("so.SetAttachFilter(_) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(&ErrUnknownProtocolOption{}, so.SetDetachFilter()); diff != "" {
+		t.Errorf("so.SetDetachFilter() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetLockFilter(t *testing.T) {
+	var handler testHandler
+	so := newTestSocketOptions(&handler, 0)
+	prog := []SockFilter{{OpCode: 0x06 /* BPF_RET|BPF_K */, K: 0xffff}}
+	if err := so.SetAttachFilter(prog); err != nil {
+		t.Fatalf("so.SetAttachFilter(_): %s", err)
+	}
+
+	if err := so.SetLockFilter(false); err != nil {
+		t.Fatalf("so.SetLockFilter(false) on unlocked filter: %s", err)
+	}
+	if err := so.SetLockFilter(true); err != nil {
+		t.Fatalf("so.SetLockFilter(true): %s", err)
+	}
+	if !so.GetLockFilter() {
+		t.Fatalf("got so.GetLockFilter() = false, want = true")
+	}
+
+	if diff := cmp.Diff(&ErrNotPermitted{}, so.SetAttachFilter(prog)); diff != "" {
+		t.Errorf("so.SetAttachFilter(_) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(&ErrNotPermitted{}, so.SetDetachFilter()); diff != "" {
+		t.Errorf("so.SetDetachFilter() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(prog, handler.filter); diff != "" {
+		t.Errorf("attached filter mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(&ErrNotPermitted{}, so.SetLockFilter(false)); diff != "" {
+		t.Errorf("so.SetLockFilter(false) mismatch (-want +got):\n%s", diff)
+	}
+	if !so.GetLockFilter() {
+		t.Errorf("got so.GetLockFilter() = false after unlock attempt, want = true")
+	}
+}
+
+// testICMPSockError is an ICMP destination unreachable socket error cause.
+type testICMPSockError struct {
+	code uint8
+}
+
+// Origin implements SockErrorCause.
+func (*testICMPSockError) Origin() SockErrOrigin {
+	return SockExtErrorOriginICMP
+}
+
+// Type implements SockErrorCause.
+func (*testICMPSockError) Type() uint8 {
+	return 3 // ICMP destination unreachable.
+}
+
+// Code implements SockErrorCause.
+func (e *testICMPSockError) Code() uint8 {
+	return e.code
+}
+
+// Info implements SockErrorCause.
+func (*testICMPSockError) Info() uint32 {
+	return 0
+}
+
+func TestNextExtendedError(t *testing.T) {
+	const (
+		ipv4ProtocolNumber NetworkProtocolNumber = 0x0800
+		mtu                                      = 1280
+		portUnreachable                          = 3
+	)
+	dst := FullAddress{Addr: "\x0a\x00\x00\x02", Port: 1234}
+	offender := FullAddress{Addr: "\x0a\x00\x00\x03"}
+
+	tests := []struct {
+		name  string
+		queue func(*SocketOptions)
+		want  ExtendedError
+	}{
+		{
+			name: "Local",
+			queue: func(so *SocketOptions) {
+				so.QueueLocalErr(&ErrMessageTooLong{}, ipv4ProtocolNumber, mtu, dst, nil)
+			},
+			want: ExtendedError{
+				Err:      &ErrMessageTooLong{},
+				Origin:   SockExtErrorOriginLocal,
+				Info:     mtu,
+				Dst:      dst,
+				NetProto: ipv4ProtocolNumber,
+			},
+		},
+		{
+			name: "ICMP",
+			queue: func(so *SocketOptions) {
+				so.QueueErr(&SockError{
+					Err:      &ErrConnectionRefused{},
+					Cause:    &testICMPSockError{code: portUnreachable},
+					Dst:      dst,
+					Offender: offender,
+					NetProto: ipv4ProtocolNumber,
+				})
+			},
+			want: ExtendedError{
+				Err:      &ErrConnectionRefused{},
+				Origin:   SockExtErrorOriginICMP,
+				Type:     3,
+				Code:     portUnreachable,
+				Offender: offender,
+				Dst:      dst,
+				NetProto: ipv4ProtocolNumber,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			so := newTestSocketOptions(&testHandler{}, 0)
+			so.SetIPv4RecvError(true)
+			if _, ok := so.NextExtendedError(); ok {
+				t.Fatalf("got so.NextExtendedError() = (_, true) on empty queue, want = (_, false)")
+			}
+
+			test.queue(so)
+			got, ok := so.NextExtendedError()
+			if !ok {
+				t.Fatalf("got so.NextExtendedError() = (_, false), want = (_, true)")
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("so.NextExtendedError() mismatch (-want +got):\n%s", diff)
+			}
+			if sockErr := so.PeekErr(); sockErr != nil {
+				t.Errorf("got so.PeekErr() = %+v, want = nil", sockErr)
+			}
+		})
+	}
+}
+
+func TestSetGetBool(t *testing.T) {
+	tests := []struct {
+		opt       SockOptBool
+		get       func(*SocketOptions) bool
+		clearable bool
+	}{
+		{BroadcastOption, (*SocketOptions).GetBroadcast, true},
+		{PassCredOption, (*SocketOptions).GetPassCred, true},
+		{NoChecksumOption, (*SocketOptions).GetNoChecksum, true},
+		{ReuseAddressOption, (*SocketOptions).GetReuseAddress, true},
+		{ReusePortOption, (*SocketOptions).GetReusePort, true},
+		{KeepAliveOption, (*SocketOptions).GetKeepAlive, true},
+		{OutOfBandInlineOption, (*SocketOptions).GetOutOfBandInline, false},
+		{LockFilterOption, (*SocketOptions).GetLockFilter, false},
+		{MulticastLoopOption, (*SocketOptions).GetMulticastLoop, true},
+		{ReceiveTOSOption, (*SocketOptions).GetReceiveTOS, true},
+		{ReceiveTTLOption, (*SocketOptions).GetReceiveTTL, true},
+		{ReceiveHopLimitOption, (*SocketOptions).GetReceiveHopLimit, true},
+		{ReceiveTClassOption, (*SocketOptions).GetReceiveTClass, true},
+		{ReceivePacketInfoOption, (*SocketOptions).GetReceivePacketInfo, true},
+		{IPv6ReceivePacketInfoOption, (*SocketOptions).GetIPv6ReceivePacketInfo, true},
+		{HeaderIncludedOption, (*SocketOptions).GetHeaderIncluded, true},
+		{V6OnlyOption, (*SocketOptions).GetV6Only, true},
+		{ReceiveOriginalDstAddressOption, (*SocketOptions).GetReceiveOriginalDstAddress, true},
+		{IPv4RecvErrorOption, (*SocketOptions).GetIPv4RecvError, true},
+		{IPv6RecvErrorOption, (*SocketOptions).GetIPv6RecvError, true},
+		{QuickAckOption, (*SocketOptions).GetQuickAck, true},
+		{DelayOption, (*SocketOptions).GetDelayOption, true},
+		{CorkOption, (*SocketOptions).GetCorkOption, true},
+		{PassSecOption, (*SocketOptions).GetPassSec, true},
+		{MulticastAllOption, (*SocketOptions).GetMulticastAll, true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d", test.opt), func(t *testing.T) {
+			so := newTestSocketOptions(&testHandler{}, tcpProtocolNumber)
+			for _, v := range []bool{true, false} {
+				if err := so.SetBool(test.opt, v); err != nil && test.clearable {
+					t.Fatalf("so.SetBool(%d, %t): %s", test.opt, v, err)
+				}
+				want := v || !test.clearable
+				if got := test.get(so); got != want {
+					t.Errorf("got getter = %t after so.SetBool(%d, %t), want = %t", got, test.opt, v, want)
+				}
+				got, err := so.GetBool(test.opt)
+				if err != nil {
+					t.Fatalf("so.GetBool(%d): %s", test.opt, err)
+				}
+				if got != want {
+					t.Errorf("got so.GetBool(%d) = %t, want = %t", test.opt, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetGetBoolUnknownOption(t *testing.T) {
+	const unknown SockOptBool = -1
+	so := newTestSocketOptions(&testHandler{}, 0)
+	if diff := cmp.Diff(&ErrUnknownProtocolOption{}, so.SetBool(unknown, true)); diff != "" {
+		t.Errorf("so.SetBool(%d, true) mismatch (-want +got):\n%s", unknown, diff)
+	}
+	if _, err := so.GetBool(unknown); err == nil {
+		t.Errorf("got so.GetBool(%d) = (_, nil), want error", unknown)
+	}
+}
+
+func TestSetGetBoolInapplicableOption(t *testing.T) {
+	for _, opt := range []SockOptBool{QuickAckOption, DelayOption, CorkOption} {
+		t.Run(fmt.Sprintf("%d", opt), func(t *testing.T) {
+			udp := newTestSocketOptions(&testHandler{}, testUDPProtocolNumber)
+			if diff := cmp.Diff(&ErrUnknownProtocolOption{}, udp.SetBool(opt, true)); diff != "" {
+				t.Errorf("udp.SetBool(%d, true) mismatch (-want +got):\n%s", opt, diff)
+			}
+			_, err := udp.GetBool(opt)
+			if diff := cmp.Diff(&ErrUnknownProtocolOption{}, err); diff != "" {
+				t.Errorf("udp.GetBool(%d) error mismatch (-want +got):\n%s", opt, diff)
+			}
+
+			tcp := newTestSocketOptions(&testHandler{}, tcpProtocolNumber)
+			if err := tcp.SetBool(opt, true); err != nil {
+				t.Errorf("tcp.SetBool(%d, true): %s", opt, err)
+			}
+			if got, err := tcp.GetBool(opt); err != nil || !got {
+				t.Errorf("got tcp.GetBool(%d) = (%t, %v), want = (true, nil)", opt, got, err)
+			}
+		})
+	}
+}
+
+func TestSetGetInt(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  SocketOptionInt
+		v    int64
+		want int64
+		get  func(*SocketOptions, *testHandler) int64
+	}{
+		{
+			name: "SendBufferSize",
+			opt:  SocketSendBufferSizeOption,
+			v:    65536,
+			want: 65536 * BufferOverheadFactor,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return so.GetSendBufferSize() },
+		},
+		{
+			name: "SendBufferSizeAboveMax",
+			opt:  SocketSendBufferSizeOption,
+			v:    math.MaxInt64,
+			want: (4 << 20) * BufferOverheadFactor,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return so.GetSendBufferSize() },
+		},
+		{
+			name: "ReceiveBufferSize",
+			opt:  SocketReceiveBufferSizeOption,
+			v:    65536,
+			want: 65536 * BufferOverheadFactor,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return so.GetReceiveBufferSize() },
+		},
+		{
+			name: "ReceiveBufferSizeForceAboveMax",
+			opt:  SocketReceiveBufferSizeForceOption,
+			v:    8 << 20,
+			want: (8 << 20) * BufferOverheadFactor,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return so.GetReceiveBufferSize() },
+		},
+		{
+			name: "ReceiveBufferSizeOverflow",
+			opt:  SocketReceiveBufferSizeForceOption,
+			v:    math.MaxInt64,
+			want: MaxSocketBufferSize,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return so.GetReceiveBufferSize() },
+		},
+		{
+			name: "ReceiveBufferSizeBelowMin",
+			opt:  SocketReceiveBufferSizeOption,
+			v:    1,
+			want: 4096,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return so.GetReceiveBufferSize() },
+		},
+		{
+			name: "ReceiveLowWaterMark",
+			opt:  ReceiveLowWaterMarkOption,
+			v:    1,
+			want: 1,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return int64(so.GetRcvlowat()) },
+		},
+		{
+			name: "BindToDevice",
+			opt:  BindToDeviceOption,
+			v:    testNICID,
+			want: testNICID,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return int64(so.GetBindToDevice()) },
+		},
+		{
+			name: "Mark",
+			opt:  MarkOption,
+			v:    math.MaxUint32,
+			want: math.MaxUint32,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return int64(so.GetMark()) },
+		},
+		{
+			name: "Priority",
+			opt:  PriorityOption,
+			v:    6,
+			want: 6,
+			get:  func(so *SocketOptions, _ *testHandler) int64 { return int64(so.GetPriority()) },
+		},
+		{
+			name: "UnicastTTL",
+			opt:  UnicastTTLOption,
+			v:    64,
+			want: 64,
+			get:  func(_ *SocketOptions, h *testHandler) int64 { return int64(h.sockOptInts[IPv4TTLOption]) },
+		},
+		{
+			name: "UnicastHopLimit",
+			opt:  UnicastHopLimitOption,
+			v:    UseDefaultIPv6HopLimit,
+			want: UseDefaultIPv6HopLimit,
+			get:  func(_ *SocketOptions, h *testHandler) int64 { return int64(h.sockOptInts[IPv6HopLimitOption]) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handler testHandler
+			so := newTestSocketOptions(&handler, 0)
+			if err := so.SetInt(test.opt, test.v); err != nil {
+				t.Fatalf("so.SetInt(%d, %d): %s", test.opt, test.v, err)
+			}
+			if got := test.get(so, &handler); got != test.want {
+				t.Errorf("got getter = %d, want = %d", got, test.want)
+			}
+			got, err := so.GetInt(test.opt)
+			if err != nil {
+				t.Fatalf("so.GetInt(%d): %s", test.opt, err)
+			}
+			if got != test.want {
+				t.Errorf("got so.GetInt(%d) = %d, want = %d", test.opt, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetGetIntInapplicableOption(t *testing.T) {
+	// The default handler does not hold any integer options.
+	so := newTestSocketOptions(&DefaultSocketOptionsHandler{}, 0)
+	if diff := cmp.Diff(&ErrUnknownProtocolOption{}, so.SetInt(UnicastTTLOption, 64)); diff != "" {
+		t.Errorf("so.SetInt(UnicastTTLOption, 64) mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := so.GetInt(UnicastTTLOption); err == nil {
+		t.Errorf("got so.GetInt(UnicastTTLOption) = (_, nil), want error")
+	}
+	if diff := cmp.Diff(&ErrInvalidOptionValue{}, so.SetInt(BindToDeviceOption, 1<<40)); diff != "" {
+		t.Errorf("so.SetInt(BindToDeviceOption, 1<<40) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(&ErrInvalidOptionValue{}, so.SetInt(MarkOption, -1)); diff != "" {
+		t.Errorf("so.SetInt(MarkOption, -1) mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDequeueErrForNetProto(t *testing.T) {
+	const (
+		ipv4ProtocolNumber NetworkProtocolNumber = 0x0800
+		ipv6ProtocolNumber NetworkProtocolNumber = 0x86dd
+	)
+
+	so := newTestSocketOptions(&testHandler{}, 0)
+	so.SetIPv4RecvError(true)
+	so.SetIPv6RecvError(true)
+	for i, proto := range []NetworkProtocolNumber{ipv6ProtocolNumber, ipv4ProtocolNumber, ipv6ProtocolNumber, ipv4ProtocolNumber} {
+		so.QueueLocalErr(&ErrMessageTooLong{}, proto, uint32(i), FullAddress{}, nil)
+	}
+
+	for _, want := range []struct {
+		proto NetworkProtocolNumber
+		info  uint32
+	}{
+		{ipv4ProtocolNumber, 1},
+		{ipv4ProtocolNumber, 3},
+		{ipv6ProtocolNumber, 0},
+		{ipv6ProtocolNumber, 2},
+	} {
+		sockErr := so.DequeueErrForNetProto(want.proto)
+		if sockErr == nil {
+			t.Fatalf("got so.DequeueErrForNetProto(%d) = nil, want error with info %d", want.proto, want.info)
+		}
+		if got := sockErr.Cause.Info(); got != want.info {
+			t.Errorf("got so.DequeueErrForNetProto(%d).Cause.Info() = %d, want = %d", want.proto, got, want.info)
+		}
+	}
+
+	for _, proto := range []NetworkProtocolNumber{ipv4ProtocolNumber, ipv6ProtocolNumber} {
+		if sockErr := so.DequeueErrForNetProto(proto); sockErr != nil {
+			t.Errorf("got so.DequeueErrForNetProto(%d) = %+v, want = nil", proto, sockErr)
+		}
+	}
+}
+
+func TestOnErrQueueNonEmpty(t *testing.T) {
+	var handler testHandler
+	so := newTestSocketOptions(&handler, 0)
+	so.SetIPv4RecvError(true)
+
+	for i := 0; i < 3; i++ {
+		so.QueueLocalErr(&ErrMessageTooLong{}, 0, 0, FullAddress{}, nil)
+	}
+	if got, want := handler.errQueueNonEmptyHits, 1; got != want {
+		t.Fatalf("got handler.errQueueNonEmptyHits = %d after consecutive enqueues, want = %d", got, want)
+	}
+
+	for so.DequeueErr() != nil {
+	}
+	so.QueueLocalErr(&ErrMessageTooLong{}, 0, 0, FullAddress{}, nil)
+	if got, want := handler.errQueueNonEmptyHits, 2; got != want {
+		t.Errorf("got handler.errQueueNonEmptyHits = %d after drain and enqueue, want = %d", got, want)
+	}
+}
+
+func TestSetRecvError(t *testing.T) {
+	so := newTestSocketOptions(&testHandler{}, 0)
+	so.SetRecvError(true)
+	if !so.GetIPv4RecvError() || !so.GetIPv6RecvError() {
+		t.Fatalf("got (so.GetIPv4RecvError(), so.GetIPv6RecvError()) = (%t, %t), want = (true, true)", so.GetIPv4RecvError(), so.GetIPv6RecvError())
+	}
+
+	so.QueueLocalErr(&ErrMessageTooLong{}, 0, 0, FullAddress{}, nil)
+	so.QueueLocalErr(&ErrMessageTooLong{}, 0, 0, FullAddress{}, nil)
+	so.SetRecvError(false)
+	if so.GetIPv4RecvError() || so.GetIPv6RecvError() {
+		t.Errorf("got (so.GetIPv4RecvError(), so.GetIPv6RecvError()) = (%t, %t), want = (false, false)", so.GetIPv4RecvError(), so.GetIPv6RecvError())
+	}
+	if sockErr := so.PeekErr(); sockErr != nil {
+		t.Errorf("got so.PeekErr() = %+v after disabling, want = nil", sockErr)
+	}
+}
+
+func TestBindToDeviceDroppedAfterNICRemoved(t *testing.T) {
+	var handler testHandler
+	so := newTestSocketOptions(&handler, 0)
+	if err := so.SetBindToDevice(testNICID); err != nil {
+		t.Fatalf("so.SetBindToDevice(%d): %s", testNICID, err)
+	}
+
+	// The NIC is removed without OnNICRemoved being called, as happens for
+	// endpoints that are not registered with the stack.
+	handler.nicRemoved = true
+	if got := so.GetBindToDevice(); got != 0 {
+		t.Errorf("got so.GetBindToDevice() = %d, want = 0", got)
+	}
+	if got := so.GetBindToDeviceName(); got != "" {
+		t.Errorf("got so.GetBindToDeviceName() = %q, want = \"\"", got)
+	}
+}
+
+func TestOnNICRemoved(t *testing.T) {
+	tests := []struct {
+		name        string
+		removed     int32
+		wantBound   int32
+		wantRemoved []int32
+	}{
+		{
+			name:        "Bound NIC",
+			removed:     testNICID,
+			wantBound:   0,
+			wantRemoved: []int32{testNICID},
+		},
+		{
+			name:        "Unrelated NIC",
+			removed:     testNICID + 1,
+			wantBound:   testNICID,
+			wantRemoved: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handler testHandler
+			so := newTestSocketOptions(&handler, 0)
+			if err := so.SetBindToDevice(testNICID); err != nil {
+				t.Fatalf("so.SetBindToDevice(%d): %s", testNICID, err)
+			}
+			so.OnNICRemoved(test.removed)
+			if got := so.GetBindToDevice(); got != test.wantBound {
+				t.Errorf("got so.GetBindToDevice() = %d, want = %d", got, test.wantBound)
+			}
+			if diff := cmp.Diff(test.wantRemoved, handler.removedBoundNICs); diff != "" {
+				t.Errorf("removed bound NICs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSetKeepAliveSeedsParams(t *testing.T) {
+	defaults := TCPKeepaliveDefaultsOption{
+		Idle:     time.Minute,
+		Interval: 10 * time.Second,
+		Count:    3,
+	}
+	custom := KeepAliveParams{
+		Idle:     time.Hour,
+		Interval: time.Second,
+		Count:    1,
+	}
+
+	tests := []struct {
+		name    string
+		proto   TransportProtocolNumber
+		initial KeepAliveParams
+		want    KeepAliveParams
+	}{
+		{
+			name:  "TCP unset",
+			proto: tcpProtocolNumber,
+			want:  KeepAliveParams(defaults),
+		},
+		{
+			name:    "TCP already set",
+			proto:   tcpProtocolNumber,
+			initial: custom,
+			want:    custom,
+		},
+		{
+			name:  "UDP",
+			proto: testUDPProtocolNumber,
+			want:  KeepAliveParams{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stack := newTestStackHandler()
+			stack.keepAlive = &defaults
+			so := newTestSocketOptionsWithStack(&testHandler{}, stack, test.proto)
+			so.SetKeepAliveParams(test.initial)
+
+			so.SetKeepAlive(true)
+			if diff := cmp.Diff(test.want, so.GetKeepAliveParams()); diff != "" {
+				t.Errorf("keepalive params mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// panicStackHandler is a StackHandler whose transport protocol options are
+// unimplemented, like the one used by AF_UNIX sockets.
+type panicStackHandler struct {
+	*testStackHandler
+}
+
+// TransportProtocolOption implements StackHandler.TransportProtocolOption.
+func (panicStackHandler) TransportProtocolOption(TransportProtocolNumber, GettableTransportProtocolOption) Error {
+	panic("unimplemented")
+}
+
+func TestSetKeepAliveWithoutTransportProtocol(t *testing.T) {
+	so := newTestSocketOptionsWithStack(&testHandler{}, panicStackHandler{newTestStackHandler()}, 0)
+
+	so.SetKeepAlive(true)
+	if !so.GetKeepAlive() {
+		t.Errorf("got so.GetKeepAlive() = false, want = true")
+	}
+	if got := so.GetKeepAliveParams(); got != (KeepAliveParams{}) {
+		t.Errorf("got so.GetKeepAliveParams() = %#v, want = {}", got)
+	}
+}
+
+func TestQueueMTUErr(t *testing.T) {
+	const (
+		mtu                                      = 1280
+		ipv6ProtocolNumber NetworkProtocolNumber = 0x86dd
+	)
+
+	so := newTestSocketOptions(&testHandler{}, testUDPProtocolNumber)
+	if got := so.LastPathMTU(); got != 0 {
+		t.Errorf("got so.LastPathMTU() = %d, want = 0", got)
+	}
+
+	so.QueueMTUErr(ipv6ProtocolNumber, mtu, FullAddress{}, nil)
+	if got := so.LastPathMTU(); got != mtu {
+		t.Errorf("got so.LastPathMTU() = %d, want = %d", got, mtu)
+	}
+
+	sockErr := so.DequeueErr()
+	if sockErr == nil {
+		t.Fatal("got so.DequeueErr() = nil, want non-nil")
+	}
+	if diff := cmp.Diff(Error(&ErrMessageTooLong{}), sockErr.Err); diff != "" {
+		t.Errorf("error mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := sockErr.Cause.Origin(), SockExtErrorOriginLocal; got != want {
+		t.Errorf("got sockErr.Cause.Origin() = %d, want = %d", got, want)
+	}
+	if got := sockErr.Cause.Info(); got != mtu {
+		t.Errorf("got sockErr.Cause.Info() = %d, want = %d", got, mtu)
+	}
+}
+
+func TestGetPathMTU(t *testing.T) {
+	tests := []struct {
+		name    string
+		pathMTU uint32
+		wantMTU uint32
+		wantErr Error
+	}{
+		{
+			name:    "Connected",
+			pathMTU: 1500,
+			wantMTU: 1500,
+		},
+		{
+			name:    "Not connected",
+			wantErr: &ErrNotConnected{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			so := newTestSocketOptions(&testHandler{pathMTU: test.pathMTU}, testUDPProtocolNumber)
+			mtu, err := so.GetPathMTU()
+			if diff := cmp.Diff(test.wantErr, err); diff != "" {
+				t.Errorf("so.GetPathMTU() error mismatch (-want +got):\n%s", diff)
+			}
+			if mtu != test.wantMTU {
+				t.Errorf("got so.GetPathMTU() = %d, want = %d", mtu, test.wantMTU)
+			}
+		})
+	}
+}
+
+func TestDefaultHandlerPathMTU(t *testing.T) {
+	so := newTestSocketOptions(&DefaultSocketOptionsHandler{}, testUDPProtocolNumber)
+	_, err := so.GetPathMTU()
+	if diff := cmp.Diff(&ErrUnknownProtocolOption{}, err); diff != "" {
+		t.Errorf("so.GetPathMTU() error mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetPeerSec(t *testing.T) {
+	const secCtx = "system_u:system_r:peer_t:s0"
+	so := newTestSocketOptions(&testHandler{peerSec: []byte(secCtx)}, 0)
+	got, err := so.GetPeerSec()
+	if err != nil {
+		t.Fatalf("so.GetPeerSec(): %s", err)
+	}
+	if string(got) != secCtx {
+		t.Errorf("got so.GetPeerSec() = %q, want = %q", got, secCtx)
+	}
+
+	so = newTestSocketOptions(&DefaultSocketOptionsHandler{}, 0)
+	_, err = so.GetPeerSec()
+	if diff := cmp.Diff(&ErrUnknownProtocolOption{}, err); diff != "" {
+		t.Errorf("so.GetPeerSec() with the default handler error mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetBindToDeviceByName(t *testing.T) {
+	so := newTestSocketOptions(&testHandler{}, 0)
+
+	if err := so.SetBindToDeviceByName(testNICName); err != nil {
+		t.Fatalf("so.SetBindToDeviceByName(%q): %s", testNICName, err)
+	}
+	if got := so.GetBindToDevice(); got != testNICID {
+		t.Errorf("got so.GetBindToDevice() = %d, want = %d", got, testNICID)
+	}
+	if got := so.GetBindToDeviceName(); got != testNICName {
+		t.Errorf("got so.GetBindToDeviceName() = %q, want = %q", got, testNICName)
+	}
+
+	const unknownName = "unknown0"
+	if diff := cmp.Diff(&ErrUnknownDevice{}, so.SetBindToDeviceByName(unknownName)); diff != "" {
+		t.Errorf("so.SetBindToDeviceByName(%q) mismatch (-want +got):\n%s", unknownName, diff)
+	}
+	if got := so.GetBindToDevice(); got != testNICID {
+		t.Errorf("got so.GetBindToDevice() = %d after failed set, want = %d", got, testNICID)
+	}
+
+	if err := so.SetBindToDeviceByName(""); err != nil {
+		t.Fatalf("so.SetBindToDeviceByName(\"\"): %s", err)
+	}
+	if got := so.GetBindToDevice(); got != 0 {
+		t.Errorf("got so.GetBindToDevice() = %d, want = 0", got)
+	}
+	if got := so.GetBindToDeviceName(); got != "" {
+		t.Errorf("got so.GetBindToDeviceName() = %q, want = \"\"", got)
+	}
+}
+
+func TestQuickAckOneShot(t *testing.T) {
+	var handler testHandler
+	so := newTestSocketOptions(&handler, tcpProtocolNumber)
+
+	if err := so.SetQuickAck(true); err != nil {
+		t.Fatalf("so.SetQuickAck(true): %s", err)
+	}
+	if diff := cmp.Diff([]bool{true}, handler.quickAckSets); diff != "" {
+		t.Errorf("quick ACK notifications mismatch (-want +got):\n%s", diff)
+	}
+	if !so.GetQuickAck() {
+		t.Errorf("got so.GetQuickAck() = false, want = true")
+	}
+
+	// The endpoint consumes the option after acting on it.
+	if !so.ConsumeQuickAck() {
+		t.Errorf("got so.ConsumeQuickAck() = false, want = true")
+	}
+	if so.GetQuickAck() {
+		t.Errorf("got so.GetQuickAck() = true after consuming, want = false")
+	}
+	if so.ConsumeQuickAck() {
+		t.Errorf("got second so.ConsumeQuickAck() = true, want = false")
+	}
+}
+
+func TestSockErrorClone(t *testing.T) {
+	const payload = "errant packet"
+
+	orig := &SockError{
+		Err:       &ErrConnectionRefused{},
+		Cause:     &testICMPSockError{code: 3},
+		Payload:   bufferv2.NewViewWithData([]byte(payload)),
+		Dst:       FullAddress{NIC: testNICID, Port: 80},
+		Offender:  FullAddress{NIC: testNICID, Port: 1234},
+		NetProto:  0x0800,
+		Timestamp: time.Unix(1, 0),
+	}
+	clone := orig.Clone()
+
+	if clone.Payload == orig.Payload {
+		t.Fatalf("clone shares the original's payload view %p", orig.Payload)
+	}
+	if got := string(clone.Payload.AsSlice()); got != payload {
+		t.Errorf("got clone.Payload = %q, want = %q", got, payload)
+	}
+	if diff := cmp.Diff(orig.Err, clone.Err); diff != "" {
+		t.Errorf("clone.Err mismatch (-want +got):\n%s", diff)
+	}
+	if clone.Cause != orig.Cause {
+		t.Errorf("got clone.Cause = %#v, want = %#v", clone.Cause, orig.Cause)
+	}
+	if clone.Dst != orig.Dst || clone.Offender != orig.Offender || clone.NetProto != orig.NetProto || !clone.Timestamp.Equal(orig.Timestamp) {
+		t.Errorf("got clone = %#v, want fields matching %#v", clone, orig)
+	}
+
+	// Each copy owns its payload; the leak check in TestMain verifies that
+	// releasing both drops all references.
+	orig.Payload.Release()
+	if got := string(clone.Payload.AsSlice()); got != payload {
+		t.Errorf("got clone.Payload = %q after releasing the original, want = %q", got, payload)
+	}
+	clone.Payload.Release()
+}
+
+func TestSockErrorEqual(t *testing.T) {
+	newErr := func() *SockError {
+		return &SockError{
+			Err:      &ErrConnectionRefused{},
+			Cause:    &testICMPSockError{code: 3},
+			Dst:      FullAddress{NIC: testNICID, Port: 80},
+			Offender: FullAddress{NIC: testNICID, Port: 1234},
+			NetProto: 0x0800,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*SockError)
+		want   bool
+	}{
+		{
+			name:   "identical",
+			modify: func(*SockError) {},
+			want:   true,
+		},
+		{
+			name: "different payload and timestamp",
+			modify: func(e *SockError) {
+				e.Payload = bufferv2.NewViewWithData([]byte("payload"))
+				e.Timestamp = time.Unix(1, 0)
+			},
+			want: true,
+		},
+		{
+			name:   "different error",
+			modify: func(e *SockError) { e.Err = &ErrMessageTooLong{} },
+			want:   false,
+		},
+		{
+			name:   "different cause",
+			modify: func(e *SockError) { e.Cause = &testICMPSockError{code: 1} },
+			want:   false,
+		},
+		{
+			name:   "nil cause",
+			modify: func(e *SockError) { e.Cause = nil },
+			want:   false,
+		},
+		{
+			name:   "different destination",
+			modify: func(e *SockError) { e.Dst.Port = 81 },
+			want:   false,
+		},
+		{
+			name:   "different offender",
+			modify: func(e *SockError) { e.Offender.Port = 1235 },
+			want:   false,
+		},
+		{
+			name:   "different network protocol",
+			modify: func(e *SockError) { e.NetProto = 0x86dd },
+			want:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := newErr(), newErr()
+			test.modify(b)
+			if got := a.Equal(b); got != test.want {
+				t.Errorf("got a.Equal(b) = %t, want = %t", got, test.want)
+			}
+			if got := b.Equal(a); got != test.want {
+				t.Errorf("got b.Equal(a) = %t, want = %t", got, test.want)
+			}
+			if b.Payload != nil {
+				b.Payload.Release()
+			}
+		})
+	}
+
+	var nilErr *SockError
+	if !nilErr.Equal(nil) {
+		t.Errorf("got nil.Equal(nil) = false, want = true")
+	}
+	if nilErr.Equal(newErr()) || newErr().Equal(nil) {
+		t.Errorf("got a nil error equal to a non-nil error")
+	}
+}
+
+func TestCoalesceLocalErrors(t *testing.T) {
+	for _, coalesce := range []bool{false, true} {
+		t.Run(fmt.Sprintf("coalesce=%t", coalesce), func(t *testing.T) {
+			so := newTestSocketOptions(&testHandler{}, 0)
+			so.SetIPv4RecvError(true)
+			so.SetCoalesceLocalErrors(coalesce)
+
+			// The first two errors are identical apart from their payloads; the
+			// third differs in its info and must always be queued.
+			so.QueueLocalErr(&ErrMessageTooLong{}, 0, 1, FullAddress{}, bufferv2.NewViewWithData([]byte("a")))
+			so.QueueLocalErr(&ErrMessageTooLong{}, 0, 1, FullAddress{}, bufferv2.NewViewWithData([]byte("b")))
+			so.QueueLocalErr(&ErrMessageTooLong{}, 0, 2, FullAddress{}, nil)
+
+			var got []uint32
+			for sockErr := so.DequeueErr(); sockErr != nil; sockErr = so.DequeueErr() {
+				got = append(got, sockErr.Cause.Info())
+				if sockErr.Payload != nil {
+					sockErr.Payload.Release()
+				}
+			}
+			want := []uint32{1, 1, 2}
+			if coalesce {
+				want = []uint32{1, 2}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("dequeued error infos mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSendBufferAutoTuneDisabled(t *testing.T) {
+	var handler testHandler
+	so := newTestSocketOptions(&handler, tcpProtocolNumber)
+
+	// Sizes set by the stack itself don't pin the buffer.
+	so.SetSendBufferSize(8192, false /* notify */)
+	if so.SendBufferAutoTuneDisabled() {
+		t.Errorf("got so.SendBufferAutoTuneDisabled() = true after an internal set, want = false")
+	}
+
+	for i := 0; i < 2; i++ {
+		so.SetSendBufferSize(16384, true /* notify */)
+		if !so.SendBufferAutoTuneDisabled() {
+			t.Errorf("got so.SendBufferAutoTuneDisabled() = false after an explicit set, want = true")
+		}
+		if handler.sendAutoTuneOffHits != 1 {
+			t.Errorf("got OnSendBufferAutoTuneDisabled calls = %d, want = 1", handler.sendAutoTuneOffHits)
+		}
+	}
+}
+
+func TestBufferSizesIncludeOverhead(t *testing.T) {
+	for _, requested := range []int64{4096, 1 << 20} {
+		t.Run(fmt.Sprintf("%d", requested), func(t *testing.T) {
+			so := newTestSocketOptions(&testHandler{}, tcpProtocolNumber)
+
+			// Like Linux, getsockopt(2) reports the requested size with the
+			// overhead included.
+			want := requested * BufferOverheadFactor
+			for _, opt := range []SocketOptionInt{SocketSendBufferSizeOption, SocketReceiveBufferSizeOption} {
+				if err := so.SetInt(opt, requested); err != nil {
+					t.Fatalf("so.SetInt(%d, %d): %s", opt, requested, err)
+				}
+				if got, err := so.GetInt(opt); err != nil || got != want {
+					t.Errorf("got so.GetInt(%d) = (%d, %v), want = (%d, nil)", opt, got, err, want)
+				}
+			}
+			if got := so.GetSendBufferSize(); got != want {
+				t.Errorf("got so.GetSendBufferSize() = %d, want = %d", got, want)
+			}
+			if got := so.GetReceiveBufferSize(); got != want {
+				t.Errorf("got so.GetReceiveBufferSize() = %d, want = %d", got, want)
+			}
+		})
+	}
+}
+
+func TestSendBufferFullTransitions(t *testing.T) {
+	const size = 100
+
+	var handler testHandler
+	so := newTestSocketOptions(&handler, tcpProtocolNumber)
+	so.SetSendBufferSize(size, false /* notify */)
+
+	for _, step := range []struct {
+		used        int64
+		size        int64
+		wantChanges []bool
+	}{
+		{used: size / 2, wantChanges: nil},
+		{used: size, wantChanges: []bool{true}},
+		{used: size + 1, wantChanges: []bool{true}},
+		{used: size - 1, wantChanges: []bool{true, false}},
+		{used: 0, wantChanges: []bool{true, false}},
+		// Shrinking the buffer below the usage fills it, growing it drains it.
+		{used: size / 2, size: size / 2, wantChanges: []bool{true, false, true}},
+		{used: size / 2, size: size, wantChanges: []bool{true, false, true, false}},
+	} {
+		if step.size != 0 {
+			so.SetSendBufferSize(step.size, false /* notify */)
+		}
+		so.UpdateSendBufferUsage(step.used)
+		if diff := cmp.Diff(step.wantChanges, handler.sendBufFullChanges); diff != "" {
+			t.Fatalf("send buffer full transitions mismatch after using %d of %d bytes (-want +got):\n%s", step.used, so.GetSendBufferSize(), diff)
+		}
+		if got, want := so.SendBufferFull(), step.used >= so.GetSendBufferSize(); got != want {
+			t.Errorf("got so.SendBufferFull() = %t after using %d of %d bytes, want = %t", got, step.used, so.GetSendBufferSize(), want)
+		}
+	}
+}
+
+func TestReceiveBufferAutoTuneDisabled(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*SocketOptions)
+	}{
+		{
+			name: "SO_RCVBUF",
+			set: func(so *SocketOptions) {
+				min, max := so.ReceiveBufferLimits()
+				so.SetReceiveBufferSize((min+max)/2, true /* notify */)
+			},
+		},
+		{
+			// SO_RCVBUFFORCE may exceed the maximum, but is otherwise set the
+			// same way.
+			name: "SO_RCVBUFFORCE",
+			set: func(so *SocketOptions) {
+				_, max := so.ReceiveBufferLimits()
+				so.SetReceiveBufferSize(2*max, true /* notify */)
+			},
+		},
+		{
+			name: "SetInt",
+			set: func(so *SocketOptions) {
+				if err := so.SetInt(SocketReceiveBufferSizeOption, 65536); err != nil {
+					t.Fatalf("so.SetInt(SocketReceiveBufferSizeOption, 65536): %s", err)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handler testHandler
+			so := newTestSocketOptions(&handler, tcpProtocolNumber)
+
+			// Sizes set by the stack itself don't pin the buffer.
+			so.SetReceiveBufferSize(8192, false /* notify */)
+			if so.ReceiveBufferAutoTuneDisabled() {
+				t.Errorf("got so.ReceiveBufferAutoTuneDisabled() = true after an internal set, want = false")
+			}
+
+			test.set(so)
+			test.set(so)
+			if !so.ReceiveBufferAutoTuneDisabled() {
+				t.Errorf("got so.ReceiveBufferAutoTuneDisabled() = false after an explicit set, want = true")
+			}
+			if handler.rcvAutoTuneOffHits != 1 {
+				t.Errorf("got OnReceiveBufferAutoTuneDisabled calls = %d, want = 1", handler.rcvAutoTuneOffHits)
+			}
+		})
+	}
+}
+
+func TestQueueICMPErrWithOffender(t *testing.T) {
+	const ipv4ProtocolNumber NetworkProtocolNumber = 0x0800
+	dst := FullAddress{NIC: testNICID, Addr: "\x0a\x00\x00\x02", Port: 53}
+	offender := FullAddress{NIC: testNICID, Addr: "\x0a\x00\x00\x01", Port: 1234}
+
+	so := newTestSocketOptions(&testHandler{}, testUDPProtocolNumber)
+	so.QueueICMPErrWithOffender(&ErrConnectionRefused{}, ipv4ProtocolNumber, testNICID, &testICMPSockError{code: 3}, dst, offender, nil)
+
+	sockErr := so.DequeueErr()
+	if sockErr == nil {
+		t.Fatal("got so.DequeueErr() = nil, want non-nil")
+	}
+	if sockErr.NICID != testNICID {
+		t.Errorf("got sockErr.NICID = %d, want = %d", sockErr.NICID, testNICID)
+	}
+	if sockErr.Dst != dst {
+		t.Errorf("got sockErr.Dst = %#v, want = %#v", sockErr.Dst, dst)
+	}
+	if sockErr.Offender != offender {
+		t.Errorf("got sockErr.Offender = %#v, want = %#v", sockErr.Offender, offender)
+	}
+	if sockErr.NetProto != ipv4ProtocolNumber {
+		t.Errorf("got sockErr.NetProto = %d, want = %d", sockErr.NetProto, ipv4ProtocolNumber)
+	}
+}
+
+func TestQueueErrWithoutCause(t *testing.T) {
+	var handler testHandler
+	so := newTestSocketOptions(&handler, testUDPProtocolNumber)
+	so.SetIPv4RecvError(true)
+
+	so.QueueErr(&SockError{
+		Err:     &ErrConnectionRefused{},
+		Payload: bufferv2.NewViewWithData([]byte("payload")),
+	})
+	if sockErr := so.PeekErr(); sockErr != nil {
+		t.Errorf("got so.PeekErr() = %+v, want = nil", sockErr)
+	}
+	if _, ok := so.NextExtendedError(); ok {
+		t.Errorf("got so.NextExtendedError() = (_, true), want = (_, false)")
+	}
+	if handler.errQueueNonEmptyHits != 0 {
+		t.Errorf("got OnErrQueueNonEmpty calls = %d, want = 0", handler.errQueueNonEmptyHits)
+	}
+}
+
+func TestNextExtendedErrorNICID(t *testing.T) {
+	const ipv6ProtocolNumber NetworkProtocolNumber = 0x86dd
+
+	so := newTestSocketOptions(&testHandler{}, testUDPProtocolNumber)
+	so.QueueICMPErrWithOffender(&ErrConnectionRefused{}, ipv6ProtocolNumber, testNICID, &testICMPSockError{code: 3}, FullAddress{}, FullAddress{}, nil)
+	so.QueueLocalErr(&ErrMessageTooLong{}, ipv6ProtocolNumber, 0, FullAddress{}, nil)
+
+	for _, want := range []NICID{testNICID, 0} {
+		extErr, ok := so.NextExtendedError()
+		if !ok {
+			t.Fatalf("got so.NextExtendedError() = (_, false), want error with NICID %d", want)
+		}
+		if extErr.NICID != want {
+			t.Errorf("got so.NextExtendedError().NICID = %d, want = %d", extErr.NICID, want)
+		}
+	}
+}
+
+func TestQueueICMPErrWithOffenderNonICMPCause(t *testing.T) {
+	so := newTestSocketOptions(&testHandler{}, testUDPProtocolNumber)
+	so.QueueICMPErrWithOffender(&ErrMessageTooLong{}, 0, 0, &LocalSockError{}, FullAddress{}, FullAddress{}, nil)
+	if sockErr := so.DequeueErr(); sockErr != nil {
+		t.Errorf("got so.DequeueErr() = %#v for a local error cause, want = nil", sockErr)
+	}
+}
+
+func TestSelectErrQueueReady(t *testing.T) {
+	var handler testHandler
+	so := newTestSocketOptions(&handler, testUDPProtocolNumber)
+	so.QueueLocalErr(&ErrMessageTooLong{}, 0, 0, FullAddress{}, nil)
+
+	if so.SelectErrQueueReady() {
+		t.Errorf("got so.SelectErrQueueReady() = true without SO_SELECT_ERR_QUEUE, want = false")
+	}
+
+	if err := so.SetBool(SelectErrQueueOption, true); err != nil {
+		t.Fatalf("so.SetBool(SelectErrQueueOption, true): %s", err)
+	}
+	if diff := cmp.Diff([]bool{true}, handler.selectErrQueueSets); diff != "" {
+		t.Errorf("SO_SELECT_ERR_QUEUE notifications mismatch (-want +got):\n%s", diff)
+	}
+	if !so.SelectErrQueueReady() {
+		t.Errorf("got so.SelectErrQueueReady() = false with a queued error, want = true")
+	}
+
+	if so.DequeueErr() == nil {
+		t.Fatal("got so.DequeueErr() = nil, want non-nil")
+	}
+	if so.SelectErrQueueReady() {
+		t.Errorf("got so.SelectErrQueueReady() = true with an empty error queue, want = false")
+	}
+}
+
+func TestSetPassSec(t *testing.T) {
+	var handler testHandler
+	so := newTestSocketOptions(&handler, testUDPProtocolNumber)
+	if so.GetPassSec() {
+		t.Fatalf("got so.GetPassSec() = true on a new socket, want = false")
+	}
+
+	for _, v := range []bool{true, false} {
+		if err := so.SetBool(PassSecOption, v); err != nil {
+			t.Fatalf("so.SetBool(PassSecOption, %t): %s", v, err)
+		}
+		if got := so.GetPassSec(); got != v {
+			t.Errorf("got so.GetPassSec() = %t, want = %t", got, v)
+		}
+	}
+	if diff := cmp.Diff([]bool{true, false}, handler.passSecSets); diff != "" {
+		t.Errorf("SO_PASSSEC notifications mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetMulticastAll(t *testing.T) {
+	var handler testHandler
+	so := newTestSocketOptions(&handler, testUDPProtocolNumber)
+	if !so.GetMulticastAll() {
+		t.Fatalf("got so.GetMulticastAll() = false on a new socket, want = true")
+	}
+
+	if err := so.SetBool(MulticastAllOption, false); err != nil {
+		t.Fatalf("so.SetBool(MulticastAllOption, false): %s", err)
+	}
+	if so.GetMulticastAll() {
+		t.Errorf("got so.GetMulticastAll() = true, want = false")
+	}
+	if diff := cmp.Diff([]bool{false}, handler.multicastAllSets); diff != "" {
+		t.Errorf("IP_MULTICAST_ALL notifications mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSockErrOriginString(t *testing.T) {
+	for _, test := range []struct {
+		origin SockErrOrigin
+		want   string
+	}{
+		{SockExtErrorOriginNone, "None"},
+		{SockExtErrorOriginLocal, "Local"},
+		{SockExtErrorOriginICMP, "ICMP"},
+		{SockExtErrorOriginICMP6, "ICMP6"},
+		{SockExtErrorOriginICMP6 + 1, "SockErrOrigin(4)"},
+	} {
+		if got := test.origin.String(); got != test.want {
+			t.Errorf("got SockErrOrigin(%d).String() = %q, want = %q", uint8(test.origin), got, test.want)
+		}
+	}
+}
+
+func TestDescribeSockErr(t *testing.T) {
+	tests := []struct {
+		name   string
+		origin SockErrOrigin
+		typ    uint8
+		code   uint8
+		want   string
+	}{
+		{
+			name:   "ICMP port unreachable",
+			origin: SockExtErrorOriginICMP,
+			typ:    3,
+			code:   3,
+			want:   "ICMP destination unreachable: port unreachable",
+		},
+		{
+			name:   "ICMP TTL exceeded",
+			origin: SockExtErrorOriginICMP,
+			typ:    11,
+			code:   0,
+			want:   "ICMP time exceeded: TTL exceeded in transit",
+		},
+		{
+			name:   "ICMPv6 address unreachable",
+			origin: SockExtErrorOriginICMP6,
+			typ:    1,
+			code:   3,
+			want:   "ICMP6 destination unreachable: address unreachable",
+		},
+		{
+			name:   "ICMPv6 packet too big",
+			origin: SockExtErrorOriginICMP6,
+			typ:    2,
+			want:   "ICMP6 packet too big",
+		},
+		{
+			name:   "Local",
+			origin: SockExtErrorOriginLocal,
+			want:   "Local error (type=0, code=0)",
+		},
+		{
+			name:   "Unknown ICMP type",
+			origin: SockExtErrorOriginICMP,
+			typ:    42,
+			code:   1,
+			want:   "ICMP error (type=42, code=1)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DescribeSockErr(test.origin, test.typ, test.code); got != test.want {
+				t.Errorf("got DescribeSockErr(%s, %d, %d) = %q, want = %q", test.origin, test.typ, test.code, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRecordOriginalDstAddress(t *testing.T) {
+	addr := FullAddress{NIC: testNICID, Addr: "\x0a\x00\x00\x01", Port: 8080}
+	so := newTestSocketOptions(&testHandler{}, testUDPProtocolNumber)
+
+	if so.RecordOriginalDstAddress(addr) {
+		t.Errorf("got so.RecordOriginalDstAddress(%#v) = true with the option disabled, want = false", addr)
+	}
+	if got, ok := so.LastOriginalDstAddress(); ok {
+		t.Errorf("got so.LastOriginalDstAddress() = (%#v, true), want = (_, false)", got)
+	}
+
+	so.SetReceiveOriginalDstAddress(true)
+	if !so.RecordOriginalDstAddress(addr) {
+		t.Errorf("got so.RecordOriginalDstAddress(%#v) = false with the option enabled, want = true", addr)
+	}
+	if got, ok := so.LastOriginalDstAddress(); !ok || got != addr {
+		t.Errorf("got so.LastOriginalDstAddress() = (%#v, %t), want = (%#v, true)", got, ok, addr)
+	}
+
+	so.SetReceiveOriginalDstAddress(false)
+	if got, ok := so.LastOriginalDstAddress(); ok {
+		t.Errorf("got so.LastOriginalDstAddress() = (%#v, true) after disabling the option, want = (_, false)", got)
+	}
+}
+
+func TestSetV6OnlyRequiresInitialState(t *testing.T) {
+	handler := &testHandler{}
+	so := newTestSocketOptions(handler, testUDPProtocolNumber)
+
+	if err := so.SetV6Only(true); err != nil {
+		t.Fatalf("so.SetV6Only(true) before bind = %s, want = nil", err)
+	}
+	if !so.GetV6Only() {
+		t.Errorf("got so.GetV6Only() = false, want = true")
+	}
+
+	handler.bound = true
+	if diff := cmp.Diff(Error(&ErrInvalidEndpointState{}), so.SetV6Only(false)); diff != "" {
+		t.Errorf("so.SetV6Only(false) after bind error mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(Error(&ErrInvalidEndpointState{}), so.SetBool(V6OnlyOption, false)); diff != "" {
+		t.Errorf("so.SetBool(V6OnlyOption, false) after bind error mismatch (-want +got):\n%s", diff)
+	}
+	if !so.GetV6Only() {
+		t.Errorf("got so.GetV6Only() = false after a rejected set, want = true")
+	}
+}
+
+func TestInitialStateGuardedSetters(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(*SocketOptions) Error
+	}{
+		{
+			name: "SetV6Only",
+			set:  func(so *SocketOptions) Error { return so.SetV6Only(true) },
+		},
+		{
+			name: "SetBool(V6OnlyOption)",
+			set:  func(so *SocketOptions) Error { return so.SetBool(V6OnlyOption, true) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := &testHandler{}
+			so := newTestSocketOptions(handler, testUDPProtocolNumber)
+			if err := test.set(so); err != nil {
+				t.Fatalf("got %s in initial state = %s, want = nil", test.name, err)
+			}
+
+			handler.bound = true
+			if diff := cmp.Diff(Error(&ErrInvalidEndpointState{}), test.set(so)); diff != "" {
+				t.Errorf("%s outside of initial state error mismatch (-want +got):\n%s", test.name, diff)
+			}
+		})
+	}
+}
+
+func TestCappedSockErrorKeepsOriginalPayloadLen(t *testing.T) {
+	const (
+		payloadLen = 100
+		capLen     = 10
+	)
+	so := newTestSocketOptions(&testHandler{}, 0)
+	so.SetIPv4RecvError(true)
+
+	sockErr := &SockError{
+		Err:      &ErrConnectionRefused{},
+		Cause:    &testICMPSockError{code: 3},
+		Payload:  bufferv2.NewViewWithData(make([]byte, payloadLen)),
+		NetProto: ipv4ProtocolNumber,
+	}
+	sockErr.CapPayload(capLen)
+	so.QueueErr(sockErr)
+
+	got, ok := so.NextExtendedError()
+	if !ok {
+		t.Fatalf("got so.NextExtendedError() = (_, false), want = (_, true)")
+	}
+	defer got.Payload.Release()
+	if size := got.Payload.Size(); size != capLen {
+		t.Errorf("got Payload.Size() = %d, want = %d", size, capLen)
+	}
+	if got.OriginalPayloadLen != payloadLen {
+		t.Errorf("got OriginalPayloadLen = %d, want = %d", got.OriginalPayloadLen, payloadLen)
+	}
+}
+
+func TestSetConnectError(t *testing.T) {
+	const ipv4ProtocolNumber NetworkProtocolNumber = 0x0800
+
+	tests := []struct {
+		name      string
+		recvErr   bool
+		wantQueue bool
+	}{
+		{
+			name:      "RECVERR enabled",
+			recvErr:   true,
+			wantQueue: true,
+		},
+		{
+			name:      "RECVERR disabled",
+			recvErr:   false,
+			wantQueue: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			so := newTestSocketOptions(&testHandler{}, tcpProtocolNumber)
+			so.SetIPv4RecvError(test.recvErr)
+
+			so.SetConnectError(&ErrConnectionRefused{}, ipv4ProtocolNumber)
+			if diff := cmp.Diff(Error(&ErrConnectionRefused{}), so.GetLastError()); diff != "" {
+				t.Errorf("so.GetLastError() mismatch (-want +got):\n%s", diff)
+			}
+
+			got, ok := so.NextExtendedError()
+			if ok != test.wantQueue {
+				t.Fatalf("got so.NextExtendedError() = (_, %t), want = (_, %t)", ok, test.wantQueue)
+			}
+			if !ok {
+				return
+			}
+			want := ExtendedError{
+				Err:      &ErrConnectionRefused{},
+				Origin:   SockExtErrorOriginLocal,
+				NetProto: ipv4ProtocolNumber,
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("so.NextExtendedError() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSockOptBoolDispatchIsExhaustive(t *testing.T) {
+	for opt := SockOptBool(0); opt < numSockOptBools; opt++ {
+		applicability, ok := SockOptBoolApplicability(opt)
+		if !ok {
+			t.Errorf("SockOptBool %d is missing from the applicability registry", opt)
+			continue
+		}
+
+		protos := map[TransportProtocolNumber]bool{
+			tcpProtocolNumber:     true,
+			testUDPProtocolNumber: applicability == ApplicableToAllSockets,
+		}
+		for proto, applicable := range protos {
+			so := newTestSocketOptions(&testHandler{}, proto)
+
+			_, unknown := so.SetBool(opt, true).(*ErrUnknownProtocolOption)
+			if unknown == applicable {
+				t.Errorf("got so.SetBool(%d, true) unknown = %t for protocol %d, want = %t", opt, unknown, proto, !applicable)
+			}
+			_, err := so.GetBool(opt)
+			_, unknown = err.(*ErrUnknownProtocolOption)
+			if unknown == applicable {
+				t.Errorf("got so.GetBool(%d) unknown = %t for protocol %d, want = %t", opt, unknown, proto, !applicable)
+			}
+		}
+	}
+}
+
+func TestSockOptIntDispatchIsExhaustive(t *testing.T) {
+	for opt := SocketOptionInt(0); opt < numSocketOptionInts; opt++ {
+		applicability, ok := SockOptIntApplicability(opt)
+		if !ok {
+			t.Errorf("SocketOptionInt %d is missing from the applicability registry", opt)
+			continue
+		}
+		if applicability != ApplicableToAllSockets {
+			t.Errorf("got SockOptIntApplicability(%d) = %d, want = %d", opt, applicability, ApplicableToAllSockets)
+		}
+
+		so := newTestSocketOptions(&testHandler{}, tcpProtocolNumber)
+		if _, unknown := so.SetInt(opt, 0).(*ErrUnknownProtocolOption); unknown {
+			t.Errorf("got so.SetInt(%d, 0) = ErrUnknownProtocolOption, want handled", opt)
+		}
+		if _, err := so.GetInt(opt); err != nil {
+			t.Errorf("so.GetInt(%d): %s", opt, err)
+		}
+	}
+}
+
+func TestBufferSizesAreClamped(t *testing.T) {
+	for _, notify := range []bool{true, false} {
+		t.Run(fmt.Sprintf("notify=%t", notify), func(t *testing.T) {
+			handler := &testHandler{}
+			so := newTestSocketOptions(handler, tcpProtocolNumber)
+
+			so.SetSendBufferSize(math.MaxInt64, notify)
+			if got := so.GetSendBufferSize(); got != MaxSocketBufferSize {
+				t.Errorf("got so.GetSendBufferSize() = %d, want = %d", got, MaxSocketBufferSize)
+			}
+			so.SetReceiveBufferSize(math.MaxInt64, notify)
+			if got := so.GetReceiveBufferSize(); got != MaxSocketBufferSize {
+				t.Errorf("got so.GetReceiveBufferSize() = %d, want = %d", got, MaxSocketBufferSize)
+			}
+
+			var want []int64
+			if notify {
+				want = []int64{MaxSocketBufferSize}
+			}
+			if diff := cmp.Diff(want, handler.sendBufSizes); diff != "" {
+				t.Errorf("send buffer sizes passed to handler mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(want, handler.rcvBufSizes); diff != "" {
+				t.Errorf("receive buffer sizes passed to handler mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSetMulticastInterface(t *testing.T) {
+	handler := &testHandler{}
+	so := newTestSocketOptions(handler, testUDPProtocolNumber)
+
+	byNIC := MulticastInterfaceOption{NIC: testNICID}
+	if err := so.SetMulticastInterface(byNIC); err != nil {
+		t.Fatalf("so.SetMulticastInterface(%#v): %s", byNIC, err)
+	}
+	if got := so.GetMulticastInterface(); got != byNIC {
+		t.Errorf("got so.GetMulticastInterface() = %#v, want = %#v", got, byNIC)
+	}
+
+	unknown := MulticastInterfaceOption{NIC: testNICID + 1}
+	if diff := cmp.Diff(&ErrBadLocalAddress{}, so.SetMulticastInterface(unknown)); diff != "" {
+		t.Errorf("so.SetMulticastInterface(%#v) mismatch (-want +got):\n%s", unknown, diff)
+	}
+	if got := so.GetMulticastInterface(); got != byNIC {
+		t.Errorf("got so.GetMulticastInterface() = %#v after failed set, want = %#v", got, byNIC)
+	}
+
+	if err := so.SetMulticastInterface(MulticastInterfaceOption{}); err != nil {
+		t.Fatalf("so.SetMulticastInterface({}): %s", err)
+	}
+	if got := so.GetMulticastInterface(); got != (MulticastInterfaceOption{}) {
+		t.Errorf("got so.GetMulticastInterface() = %#v, want = {}", got)
+	}
+
+	want := []MulticastInterfaceOption{byNIC, {}}
+	if diff := cmp.Diff(want, handler.multicastIfaces); diff != "" {
+		t.Errorf("multicast interface notifications mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReceiveBufferShrinkDoesNotUpdateWindow(t *testing.T) {
+	handler := &testHandler{}
+	so := newTestSocketOptions(handler, tcpProtocolNumber)
+
+	steps := []struct {
+		size              int64
+		wantWindowUpdates int
+	}{
+		{size: 4096, wantWindowUpdates: 1},
+		// Shrinking must not retract the advertised window.
+		{size: 1024, wantWindowUpdates: 1},
+		{size: 1024, wantWindowUpdates: 1},
+		{size: 8192, wantWindowUpdates: 2},
+	}
+	for _, step := range steps {
+		so.SetReceiveBufferSize(step.size, true /* notify */)
+		if handler.windowUpdates != step.wantWindowUpdates {
+			t.Errorf("got window updates = %d after setting the receive buffer size to %d, want = %d", handler.windowUpdates, step.size, step.wantWindowUpdates)
+		}
+	}
+}
+
+// postSetHandler is a SocketOptionsHandler which always returns a receive
+// buffer postSet callback.
+type postSetHandler struct {
+	DefaultSocketOptionsHandler
+
+	postSets int
+}
+
+// OnSetReceiveBufferSize implements SocketOptionsHandler.OnSetReceiveBufferSize.
+func (h *postSetHandler) OnSetReceiveBufferSize(v, oldSz int64) (int64, func()) {
+	return v, func() { h.postSets++ }
+}
+
+func TestReceiveBufferPostSetLeftToHandler(t *testing.T) {
+	handler := &postSetHandler{}
+	so := newTestSocketOptions(handler, tcpProtocolNumber)
+
+	for i, size := range []int64{8192, 4096} {
+		so.SetReceiveBufferSize(size, true /* notify */)
+		if got, want := handler.postSets, i+1; got != want {
+			t.Errorf("got postSet calls = %d after setting the receive buffer size to %d, want = %d", got, size, want)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	refs.SetLeakMode(refs.LeaksPanic)
+	code := m.Run()
+	refs.DoLeakCheck()
+	os.Exit(code)
+}
@@ -515,6 +515,12 @@ func (r *Route) MTU() uint32 {
 	return r.outgoingNIC.getNetworkEndpoint(r.NetProto()).MTU()
 }
 
+// LinkMTU returns the MTU of the link the route goes out of. Unlike MTU, it
+// includes the network header, matching the path MTU reported by IP_MTU.
+func (r *Route) LinkMTU() uint32 {
+	return r.outgoingNIC.MTU()
+}
+
 // Release decrements the reference counter of the resources associated with the
 // route.
 func (r *Route) Release() {
@@ -944,9 +944,21 @@ func (s *Stack) CheckNIC(id tcpip.NICID) bool {
 // RemoveNIC removes NIC and all related routes from the network stack.
 func (s *Stack) RemoveNIC(id tcpip.NICID) tcpip.Error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	err := s.removeNICLocked(id)
+	s.mu.Unlock()
+	if _, ok := err.(*tcpip.ErrUnknownNICID); ok {
+		return err
+	}
 
-	return s.removeNICLocked(id)
+	// Endpoints bound to the removed NIC with SO_BINDTODEVICE lose their
+	// binding. Endpoints that are not registered drop it lazily, see
+	// tcpip.SocketOptions.GetBindToDevice.
+	for _, e := range s.RegisteredEndpoints() {
+		if ep, ok := e.(tcpip.Endpoint); ok {
+			ep.SocketOptions().OnNICRemoved(int32(id))
+		}
+	}
+	return err
 }
 
 // removeNICLocked removes NIC and all related routes from the network stack.
@@ -1019,6 +1031,29 @@ func (s *Stack) HasNIC(id tcpip.NICID) bool {
 	return ok
 }
 
+// NICIDForName returns the ID of the NIC with the provided name.
+func (s *Stack) NICIDForName(name string) (tcpip.NICID, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for id, nic := range s.nics {
+		if nic.Name() == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// NICName returns the name of the NIC with the provided ID.
+func (s *Stack) NICName(id tcpip.NICID) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	nic, ok := s.nics[id]
+	if !ok {
+		return "", false
+	}
+	return nic.Name(), true
+}
+
 // NICInfo returns a map of NICIDs to their associated information.
 func (s *Stack) NICInfo() map[tcpip.NICID]NICInfo {
 	s.mu.RLock()
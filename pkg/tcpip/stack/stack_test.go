@@ -42,6 +42,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/testutil"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
 )
 
 const (
@@ -1016,6 +1017,66 @@ func TestRemoveNIC(t *testing.T) {
 	}
 }
 
+func TestRemoveNICClearsBindToDevice(t *testing.T) {
+	const nicID = 1
+
+	for _, test := range []struct {
+		name string
+		bind bool
+		// wantErr is the error the endpoint reports after the NIC is removed.
+		wantErr tcpip.Error
+	}{
+		{
+			name:    "bound",
+			bind:    true,
+			wantErr: &tcpip.ErrNetworkDown{},
+		},
+		{
+			// Endpoints that are not registered with the stack drop the
+			// binding lazily.
+			name:    "not bound",
+			bind:    false,
+			wantErr: nil,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+				TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+			})
+			if err := s.CreateNIC(nicID, channel.New(0, defaultMTU, "")); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+
+			var wq waiter.Queue
+			ep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+			if err != nil {
+				t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+			}
+			defer ep.Close()
+
+			if err := ep.SocketOptions().SetBindToDevice(nicID); err != nil {
+				t.Fatalf("SetBindToDevice(%d): %s", nicID, err)
+			}
+			if test.bind {
+				if err := ep.Bind(tcpip.FullAddress{Port: 1234}); err != nil {
+					t.Fatalf("Bind(_): %s", err)
+				}
+			}
+
+			if err := s.RemoveNIC(nicID); err != nil {
+				t.Fatalf("s.RemoveNIC(%d): %s", nicID, err)
+			}
+			if got := ep.SocketOptions().GetBindToDevice(); got != 0 {
+				t.Errorf("got GetBindToDevice() = %d after RemoveNIC, want = 0", got)
+			}
+			if got := ep.LastError(); got != test.wantErr {
+				t.Errorf("got LastError() = %v, want = %v", got, test.wantErr)
+			}
+		})
+	}
+}
+
 func TestRouteWithDownNIC(t *testing.T) {
 	tests := []struct {
 		name   string
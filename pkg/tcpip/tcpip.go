@@ -958,6 +958,12 @@ func (*TCPReceiveBufferSizeRangeOption) isGettableTransportProtocolOption() {}
 
 func (*TCPReceiveBufferSizeRangeOption) isSettableTransportProtocolOption() {}
 
+// TCPKeepaliveDefaultsOption is the keepalive parameters used by TCP sockets
+// which have not configured their own.
+type TCPKeepaliveDefaultsOption KeepAliveParams
+
+func (*TCPKeepaliveDefaultsOption) isGettableTransportProtocolOption() {}
+
 // TCPAvailableCongestionControlOption is the supported congestion control
 // algorithms for TCP
 type TCPAvailableCongestionControlOption string
@@ -1218,12 +1224,6 @@ type RemoveMembershipOption MembershipOption
 
 func (*RemoveMembershipOption) isSettableSocketOption() {}
 
-// SocketDetachFilterOption is used by SetSockOpt to detach a previously attached
-// classic BPF filter on a given endpoint.
-type SocketDetachFilterOption int
-
-func (*SocketDetachFilterOption) isSettableSocketOption() {}
-
 // OriginalDestinationOption is used to get the original destination address
 // and port of a redirected packet.
 type OriginalDestinationOption FullAddress
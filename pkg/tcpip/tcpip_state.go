@@ -25,3 +25,11 @@ func (c *ReceivableControlMessages) saveTimestamp() int64 {
 func (c *ReceivableControlMessages) loadTimestamp(nsec int64) {
 	c.Timestamp = time.Unix(0, nsec)
 }
+
+func (s *SockError) saveTimestamp() int64 {
+	return s.Timestamp.UnixNano()
+}
+
+func (s *SockError) loadTimestamp(nsec int64) {
+	s.Timestamp = time.Unix(0, nsec)
+}
@@ -261,6 +261,7 @@ func TestTCPLinkResolutionFailure(t *testing.T) {
 					Addr: utils.Ipv4Addr1.AddressWithPrefix.Address,
 				},
 				NetProto: ipv4.ProtocolNumber,
+				NICID:    host1NICID,
 			},
 			transErr: transportError{
 				origin: tcpip.SockExtErrorOriginICMP,
@@ -286,6 +287,7 @@ func TestTCPLinkResolutionFailure(t *testing.T) {
 					Addr: utils.Ipv6Addr1.AddressWithPrefix.Address,
 				},
 				NetProto: ipv6.ProtocolNumber,
+				NICID:    host1NICID,
 			},
 			transErr: transportError{
 				origin: tcpip.SockExtErrorOriginICMP6,
@@ -390,6 +392,7 @@ func TestTCPLinkResolutionFailure(t *testing.T) {
 				checker.IgnoreCmpPath(
 					// Ignore the payload since we do not know the TCP seq/ack numbers.
 					"Payload",
+					"OriginalPayloadLen",
 					// Ignore the cause since we will compare its properties separately
 					// since the concrete type of the cause is unknown.
 					"Cause",
@@ -79,6 +79,9 @@ type endpoint struct {
 	// during restore.
 	frozen bool
 	ident  uint16
+	// boundBindToDevice is the NIC the endpoint was registered with. It is
+	// kept separately as SO_BINDTODEVICE may change or be cleared afterwards.
+	boundBindToDevice tcpip.NICID
 }
 
 func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, tcpip.Error) {
@@ -89,6 +92,7 @@ func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, transProt
 		uniqueID:    s.UniqueID(),
 	}
 	ep.ops.InitHandler(ep, ep.stack, tcpip.GetStackSendBufferLimits, tcpip.GetStackReceiveBufferLimits)
+	ep.ops.SetTransportProtocol(transProto)
 	ep.ops.SetSendBufferSize(32*1024, false /* notify */)
 	ep.ops.SetReceiveBufferSize(32*1024, false /* notify */)
 	ep.net.Init(s, netProto, transProto, &ep.ops, waiterQueue)
@@ -134,7 +138,7 @@ func (e *endpoint) Close() {
 		case transport.DatagramEndpointStateBound, transport.DatagramEndpointStateConnected:
 			info := e.net.Info()
 			info.ID.LocalPort = e.ident
-			e.stack.UnregisterTransportEndpoint([]tcpip.NetworkProtocolNumber{info.NetProto}, e.transProto, info.ID, e, ports.Flags{}, tcpip.NICID(e.ops.GetBindToDevice()))
+			e.stack.UnregisterTransportEndpoint([]tcpip.NetworkProtocolNumber{info.NetProto}, e.transProto, info.ID, e, ports.Flags{}, e.boundBindToDevice)
 		default:
 			panic(fmt.Sprintf("unhandled state = %s", state))
 		}
@@ -198,34 +202,35 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 		HasTimestamp: true,
 		Timestamp:    p.receivedAt,
 	}
+	cmsgs := e.ops.ReceiveCmsgMask()
 	switch netProto := e.net.NetProto(); netProto {
 	case header.IPv4ProtocolNumber:
-		if e.ops.GetReceiveTOS() {
+		if cmsgs.Has(tcpip.CmsgMaskTOS) {
 			cm.HasTOS = true
 			cm.TOS = p.tosOrTClass
 		}
-		if e.ops.GetReceivePacketInfo() {
+		if cmsgs.Has(tcpip.CmsgMaskPacketInfo) {
 			cm.HasIPPacketInfo = true
 			cm.PacketInfo = p.packetInfo
 		}
-		if e.ops.GetReceiveTTL() {
+		if cmsgs.Has(tcpip.CmsgMaskTTL) {
 			cm.HasTTL = true
 			cm.TTL = p.ttlOrHopLimit
 		}
 	case header.IPv6ProtocolNumber:
-		if e.ops.GetReceiveTClass() {
+		if cmsgs.Has(tcpip.CmsgMaskTClass) {
 			cm.HasTClass = true
 			// Although TClass is an 8-bit value it's read in the CMsg as a uint32.
 			cm.TClass = uint32(p.tosOrTClass)
 		}
-		if e.ops.GetIPv6ReceivePacketInfo() {
+		if cmsgs.Has(tcpip.CmsgMaskIPv6PacketInfo) {
 			cm.HasIPv6PacketInfo = true
 			cm.IPv6PacketInfo = tcpip.IPv6PacketInfo{
 				NIC:  p.packetInfo.NIC,
 				Addr: p.packetInfo.DestinationAddr,
 			}
 		}
-		if e.ops.GetReceiveHopLimit() {
+		if cmsgs.Has(tcpip.CmsgMaskHopLimit) {
 			cm.HasHopLimit = true
 			cm.HopLimit = p.ttlOrHopLimit
 		}
@@ -373,6 +378,17 @@ func (e *endpoint) HasNIC(id int32) bool {
 	return e.stack.HasNIC(tcpip.NICID(id))
 }
 
+// NICIDForName implements tcpip.SocketOptionsHandler.NICIDForName.
+func (e *endpoint) NICIDForName(name string) (int32, bool) {
+	id, ok := e.stack.NICIDForName(name)
+	return int32(id), ok
+}
+
+// NICName implements tcpip.SocketOptionsHandler.NICName.
+func (e *endpoint) NICName(id int32) (string, bool) {
+	return e.stack.NICName(tcpip.NICID(id))
+}
+
 // SetSockOpt implements tcpip.Endpoint.
 func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 	return e.net.SetSockOpt(opt)
@@ -580,7 +596,11 @@ func (e *endpoint) registerWithStack(netProto tcpip.NetworkProtocolNumber, id st
 	if id.LocalPort != 0 {
 		// The endpoint already has a local port, just attempt to
 		// register it.
-		return id, e.stack.RegisterTransportEndpoint([]tcpip.NetworkProtocolNumber{netProto}, e.transProto, id, e, ports.Flags{}, bindToDevice)
+		err := e.stack.RegisterTransportEndpoint([]tcpip.NetworkProtocolNumber{netProto}, e.transProto, id, e, ports.Flags{}, bindToDevice)
+		if err == nil {
+			e.boundBindToDevice = bindToDevice
+		}
+		return id, err
 	}
 
 	// We need to find a port for the endpoint.
@@ -589,6 +609,7 @@ func (e *endpoint) registerWithStack(netProto tcpip.NetworkProtocolNumber, id st
 		err := e.stack.RegisterTransportEndpoint([]tcpip.NetworkProtocolNumber{netProto}, e.transProto, id, e, ports.Flags{}, bindToDevice)
 		switch err.(type) {
 		case nil:
+			e.boundBindToDevice = bindToDevice
 			return true, nil
 		case *tcpip.ErrPortInUse:
 			return false, nil
@@ -63,12 +63,6 @@ type Endpoint struct {
 	// TODO(https://gvisor.dev/issue/6389): Use different fields for IPv4/IPv6.
 	// +checklocks:mu
 	multicastTTL uint8
-	// TODO(https://gvisor.dev/issue/6389): Use different fields for IPv4/IPv6.
-	// +checklocks:mu
-	multicastAddr tcpip.Address
-	// TODO(https://gvisor.dev/issue/6389): Use different fields for IPv4/IPv6.
-	// +checklocks:mu
-	multicastNICID tcpip.NICID
 	// +checklocks:mu
 	ipv4TOS uint8
 	// +checklocks:mu
@@ -416,8 +410,9 @@ func (e *Endpoint) AcquireContextForWrite(opts tcpip.WriteOptions) (WriteContext
 		to = &tcpip.FullAddress{
 			// RegisterNICID is set when the endpoint is connected. It is usually
 			// only set for link-local addresses or multicast addresses if the
-			// multicast interface was specified (see e.multicastNICID,
-			// e.connectRouteRLocked and e.ConnectAndThen).
+			// multicast interface was specified (see
+			// tcpip.SocketOptions.GetMulticastInterface, e.connectRouteRLocked
+			// and e.ConnectAndThen).
 			NIC:  info.RegisterNICID,
 			Addr: info.ID.RemoteAddress,
 		}
@@ -574,11 +569,14 @@ func (e *Endpoint) connectRouteRLocked(nicID tcpip.NICID, localAddr tcpip.Addres
 		}
 
 		if header.IsV4MulticastAddress(addr.Addr) || header.IsV6MulticastAddress(addr.Addr) {
+			// TODO(https://gvisor.dev/issue/6389): Use different options for
+			// IPv4/IPv6.
+			multicastIf := e.ops.GetMulticastInterface()
 			if nicID == 0 {
-				nicID = e.multicastNICID
+				nicID = multicastIf.NIC
 			}
 			if localAddr == "" && nicID == 0 {
-				localAddr = e.multicastAddr
+				localAddr = multicastIf.InterfaceAddr
 			}
 		}
 	}
@@ -791,6 +789,17 @@ func (e *Endpoint) GetRemoteAddress() (tcpip.FullAddress, bool) {
 	}, true
 }
 
+// PathMTU returns the path MTU of the connected route, as reported by IP_MTU.
+func (e *Endpoint) PathMTU() (uint32, tcpip.Error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.State() != transport.DatagramEndpointStateConnected {
+		return 0, &tcpip.ErrNotConnected{}
+	}
+	return e.connectedRoute.LinkMTU(), nil
+}
+
 // SetSockOptInt sets the socket option.
 func (e *Endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) tcpip.Error {
 	switch opt {
@@ -872,6 +881,21 @@ func (e *Endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, tcpip.Error) {
 	}
 }
 
+// AcceptsMulticast returns whether a packet sent to the multicast address addr
+// and received on nicID should be delivered to the endpoint. With
+// IP_MULTICAST_ALL enabled, which is the default, every such packet is;
+// otherwise only packets for groups the endpoint joined on nicID are.
+func (e *Endpoint) AcceptsMulticast(nicID tcpip.NICID, addr tcpip.Address) bool {
+	if e.ops.GetMulticastAll() {
+		return true
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.multicastMemberships[multicastMembership{nicID: nicID, multicastAddr: addr}]
+	return ok
+}
+
 // SetSockOpt sets the socket option.
 func (e *Endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 	switch v := opt.(type) {
@@ -888,9 +912,7 @@ func (e *Endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 		addr := fa.Addr
 
 		if nic == 0 && addr == "" {
-			e.multicastAddr = ""
-			e.multicastNICID = 0
-			break
+			return e.ops.SetMulticastInterface(tcpip.MulticastInterfaceOption{})
 		}
 
 		if nic != 0 {
@@ -908,8 +930,10 @@ func (e *Endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 			return &tcpip.ErrInvalidEndpointState{}
 		}
 
-		e.multicastNICID = nic
-		e.multicastAddr = addr
+		return e.ops.SetMulticastInterface(tcpip.MulticastInterfaceOption{
+			NIC:           nic,
+			InterfaceAddr: addr,
+		})
 
 	case *tcpip.AddMembershipOption:
 		if !(header.IsV4MulticastAddress(v.MulticastAddr) && e.netProto == header.IPv4ProtocolNumber) && !(header.IsV6MulticastAddress(v.MulticastAddr) && e.netProto == header.IPv6ProtocolNumber) {
@@ -981,9 +1005,6 @@ func (e *Endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 		}
 
 		delete(e.multicastMemberships, memToRemove)
-
-	case *tcpip.SocketDetachFilterOption:
-		return nil
 	}
 	return nil
 }
@@ -992,12 +1013,7 @@ func (e *Endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 func (e *Endpoint) GetSockOpt(opt tcpip.GettableSocketOption) tcpip.Error {
 	switch o := opt.(type) {
 	case *tcpip.MulticastInterfaceOption:
-		e.mu.Lock()
-		*o = tcpip.MulticastInterfaceOption{
-			NIC:           e.multicastNICID,
-			InterfaceAddr: e.multicastAddr,
-		}
-		e.mu.Unlock()
+		*o = e.ops.GetMulticastInterface()
 
 	default:
 		return &tcpip.ErrUnknownProtocolOption{}
@@ -362,14 +362,8 @@ func (ep *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 // SetSockOpt implements tcpip.Endpoint.SetSockOpt. Packet sockets cannot be
 // used with SetSockOpt, and this function always returns
 // *tcpip.ErrNotSupported.
-func (ep *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
-	switch opt.(type) {
-	case *tcpip.SocketDetachFilterOption:
-		return nil
-
-	default:
-		return &tcpip.ErrUnknownProtocolOption{}
-	}
+func (*endpoint) SetSockOpt(tcpip.SettableSocketOption) tcpip.Error {
+	return &tcpip.ErrUnknownProtocolOption{}
 }
 
 // SetSockOptInt implements tcpip.Endpoint.SetSockOptInt.
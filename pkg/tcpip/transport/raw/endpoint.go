@@ -175,6 +175,17 @@ func (e *endpoint) HasNIC(id int32) bool {
 	return e.stack.HasNIC(tcpip.NICID(id))
 }
 
+// NICIDForName implements tcpip.SocketOptionsHandler.NICIDForName.
+func (e *endpoint) NICIDForName(name string) (int32, bool) {
+	id, ok := e.stack.NICIDForName(name)
+	return int32(id), ok
+}
+
+// NICName implements tcpip.SocketOptionsHandler.NICName.
+func (e *endpoint) NICName(id int32) (string, bool) {
+	return e.stack.NICName(tcpip.NICID(id))
+}
+
 // Abort implements stack.TransportEndpoint.Abort.
 func (e *endpoint) Abort() {
 	e.Close()
@@ -251,31 +262,32 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 		HasTimestamp: true,
 		Timestamp:    pkt.receivedAt,
 	}
+	cmsgs := e.ops.ReceiveCmsgMask()
 	switch netProto := e.net.NetProto(); netProto {
 	case header.IPv4ProtocolNumber:
-		if e.ops.GetReceiveTOS() {
+		if cmsgs.Has(tcpip.CmsgMaskTOS) {
 			cm.HasTOS = true
 			cm.TOS = pkt.tosOrTClass
 		}
-		if e.ops.GetReceiveTTL() {
+		if cmsgs.Has(tcpip.CmsgMaskTTL) {
 			cm.HasTTL = true
 			cm.TTL = pkt.ttlOrHopLimit
 		}
-		if e.ops.GetReceivePacketInfo() {
+		if cmsgs.Has(tcpip.CmsgMaskPacketInfo) {
 			cm.HasIPPacketInfo = true
 			cm.PacketInfo = pkt.packetInfo
 		}
 	case header.IPv6ProtocolNumber:
-		if e.ops.GetReceiveTClass() {
+		if cmsgs.Has(tcpip.CmsgMaskTClass) {
 			cm.HasTClass = true
 			// Although TClass is an 8-bit value it's read in the CMsg as a uint32.
 			cm.TClass = uint32(pkt.tosOrTClass)
 		}
-		if e.ops.GetReceiveHopLimit() {
+		if cmsgs.Has(tcpip.CmsgMaskHopLimit) {
 			cm.HasHopLimit = true
 			cm.HopLimit = pkt.ttlOrHopLimit
 		}
-		if e.ops.GetIPv6ReceivePacketInfo() {
+		if cmsgs.Has(tcpip.CmsgMaskIPv6PacketInfo) {
 			cm.HasIPv6PacketInfo = true
 			cm.IPv6PacketInfo = tcpip.IPv6PacketInfo{
 				NIC:  pkt.packetInfo.NIC,
@@ -487,9 +499,6 @@ func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 // SetSockOpt implements tcpip.Endpoint.SetSockOpt.
 func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 	switch opt := opt.(type) {
-	case *tcpip.SocketDetachFilterOption:
-		return nil
-
 	case *tcpip.ICMPv6Filter:
 		if e.net.NetProto() != header.IPv6ProtocolNumber {
 			return &tcpip.ErrUnknownProtocolOption{}
@@ -645,6 +654,12 @@ func (e *endpoint) HandlePacket(pkt stack.PacketBufferPtr) {
 			panic(fmt.Sprintf("unhandled state = %s", state))
 		}
 
+		if (header.IsV4MulticastAddress(dstAddr) || header.IsV6MulticastAddress(dstAddr)) && !e.net.AcceptsMulticast(pkt.NICID, dstAddr) {
+			// IP_MULTICAST_ALL is disabled and the endpoint did not join the
+			// group on the receiving interface.
+			return false
+		}
+
 		wasEmpty := e.rcvBufSize == 0
 
 		// Push new packet into receive list and increment the buffer size.
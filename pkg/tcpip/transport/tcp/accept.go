@@ -197,7 +197,8 @@ func (l *listenContext) createConnectingEndpoint(s *segment, rcvdSynOpts header.
 
 	n = newEndpoint(l.stack, l.protocol, netProto, queue)
 	n.mu.Lock()
-	n.ops.SetV6Only(l.v6Only)
+	// The new endpoint is still in its initial state, so this cannot fail.
+	_ = n.ops.SetV6Only(l.v6Only)
 	n.TransportEndpointInfo.ID = s.id
 	n.boundNICID = s.pkt.NICID
 	n.route = route
@@ -412,6 +412,13 @@ type endpoint struct {
 	ipv6HopLimit      int16
 	isConnectNotified bool
 
+	// quickAck is set when the user enables TCP_QUICKACK on a connected
+	// endpoint. While set, received data is acknowledged as soon as it is
+	// consumed instead of once all queued segments have been processed.
+	//
+	// +checklocks:mu
+	quickAck bool
+
 	// h stores a reference to the current handshake state if the endpoint is in
 	// the SYN-SENT or SYN-RECV states, in which case endpoint == endpoint.h.ep.
 	// nil otherwise.
@@ -851,8 +858,11 @@ func newEndpoint(s *stack.Stack, protocol *protocol, netProto tcpip.NetworkProto
 		maxSynRetries: DefaultSynRetries,
 	}
 	e.ops.InitHandler(e, e.stack, GetTCPSendBufferLimits, GetTCPReceiveBufferLimits)
+	e.ops.SetTransportProtocol(ProtocolNumber)
 	e.ops.SetMulticastLoop(true)
-	e.ops.SetQuickAck(true)
+	if err := e.ops.SetQuickAck(true); err != nil {
+		panic(fmt.Sprintf("e.ops.SetQuickAck(true) = %s", err))
+	}
 	e.ops.SetSendBufferSize(DefaultSendBufferSize, false /* notify */)
 	e.ops.SetReceiveBufferSize(DefaultReceiveBufferSize, false /* notify */)
 
@@ -998,6 +1008,7 @@ func (e *endpoint) purgeWriteQueue() {
 			s.DecRef()
 		}
 		e.sndQueueInfo.SndBufUsed = 0
+		e.ops.UpdateSendBufferUsage(0)
 		e.sndQueueInfo.SndClosed = true
 	}
 }
@@ -1600,6 +1611,7 @@ func (e *endpoint) queueSegment(p tcpip.Payloader, opts tcpip.WriteOptions) (*se
 	size := int(buf.Size())
 	s := newOutgoingSegment(e.TransportEndpointInfo.ID, e.stack.Clock(), buf)
 	e.sndQueueInfo.SndBufUsed += size
+	e.ops.UpdateSendBufferUsage(int64(e.sndQueueInfo.SndBufUsed))
 	s.IncRef()
 	e.snd.writeList.PushBack(s)
 
@@ -1717,12 +1729,63 @@ func (e *endpoint) OnReusePortSet(v bool) {
 }
 
 // OnKeepAliveSet implements tcpip.SocketOptionsHandler.OnKeepAliveSet.
-func (e *endpoint) OnKeepAliveSet(bool) {
+func (e *endpoint) OnKeepAliveSet(v bool) {
 	e.LockUser()
+	if p := e.ops.GetKeepAliveParams(); v && p != (tcpip.KeepAliveParams{}) {
+		// The parameters were either seeded from the stack defaults when
+		// keepalive was first enabled or mirror the endpoint's own, see
+		// syncKeepAliveParams.
+		e.keepalive.Lock()
+		e.keepalive.idle = p.Idle
+		e.keepalive.interval = p.Interval
+		e.keepalive.count = p.Count
+		e.keepalive.Unlock()
+	}
 	e.resetKeepaliveTimer(true /* receivedData */)
 	e.UnlockUser()
 }
 
+// syncKeepAliveParams records the endpoint's keepalive parameters in its
+// socket options, so they are not replaced by the stack defaults when
+// keepalive is enabled later.
+//
+// Precondition: e.keepalive must be locked.
+func (e *endpoint) syncKeepAliveParams() {
+	e.ops.SetKeepAliveParams(tcpip.KeepAliveParams{
+		Idle:     e.keepalive.idle,
+		Interval: e.keepalive.interval,
+		Count:    e.keepalive.count,
+	})
+}
+
+// OnQuickAckSet implements tcpip.SocketOptionsHandler.OnQuickAckSet.
+func (e *endpoint) OnQuickAckSet(v bool) {
+	e.LockUser()
+	defer e.UnlockUser()
+
+	// Quick-ACK mode is the default until the connection is established, so
+	// it is only entered once connected.
+	e.quickAck = v && e.EndpointState().connected()
+	if !e.quickAck {
+		return
+	}
+	// Acknowledge any data that was received but not yet acknowledged
+	// right away.
+	if e.rcv.RcvNxt != e.snd.MaxSentAck {
+		e.sendQuickAck()
+	}
+}
+
+// sendQuickAck sends an ACK and leaves quick-ACK mode. Like Linux, the mode
+// entered with TCP_QUICKACK is not permanent, so the option is consumed.
+//
+// +checklocks:e.mu
+func (e *endpoint) sendQuickAck() {
+	e.quickAck = false
+	e.ops.ConsumeQuickAck()
+	e.snd.sendAck()
+}
+
 // OnDelayOptionSet implements tcpip.SocketOptionsHandler.OnDelayOptionSet.
 func (e *endpoint) OnDelayOptionSet(v bool) {
 	if !v {
@@ -1752,6 +1815,10 @@ func (e *endpoint) getSendBufferSize() int {
 }
 
 // OnSetReceiveBufferSize implements tcpip.SocketOptionsHandler.OnSetReceiveBufferSize.
+//
+// Shrinking the buffer never retracts the advertised window: the receiver
+// keeps RcvAcc in place until the window catches up with it again, see
+// receiver.getSendParams.
 func (e *endpoint) OnSetReceiveBufferSize(rcvBufSz, oldSz int64) (newSz int64, postSet func()) {
 	e.LockUser()
 
@@ -1770,7 +1837,6 @@ func (e *endpoint) OnSetReceiveBufferSize(rcvBufSz, oldSz int64) (newSz int64, p
 
 	availBefore := wndFromSpace(e.receiveBufferAvailableLocked(int(oldSz)))
 	availAfter := wndFromSpace(e.receiveBufferAvailableLocked(int(rcvBufSz)))
-	e.RcvAutoParams.Disabled = true
 
 	// Immediately send an ACK to uncork the sender silly window
 	// syndrome prevetion, when our available space grows above aMSS
@@ -1793,10 +1859,18 @@ func (e *endpoint) OnSetReceiveBufferSize(rcvBufSz, oldSz int64) (newSz int64, p
 	return rcvBufSz, postSet
 }
 
-// OnSetSendBufferSize implements tcpip.SocketOptionsHandler.OnSetSendBufferSize.
-func (e *endpoint) OnSetSendBufferSize(sz int64) int64 {
+// OnReceiveBufferAutoTuneDisabled implements
+// tcpip.SocketOptionsHandler.OnReceiveBufferAutoTuneDisabled.
+func (e *endpoint) OnReceiveBufferAutoTuneDisabled() {
+	e.rcvQueueMu.Lock()
+	e.RcvAutoParams.Disabled = true
+	e.rcvQueueMu.Unlock()
+}
+
+// OnSendBufferAutoTuneDisabled implements
+// tcpip.SocketOptionsHandler.OnSendBufferAutoTuneDisabled.
+func (e *endpoint) OnSendBufferAutoTuneDisabled() {
 	e.sndQueueInfo.TCPSndBufState.AutoTuneSndBufDisabled.Store(1)
-	return sz
 }
 
 // WakeupWriters implements tcpip.SocketOptionsHandler.WakeupWriters.
@@ -1824,6 +1898,7 @@ func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) tcpip.Error {
 		e.LockUser()
 		e.keepalive.Lock()
 		e.keepalive.count = v
+		e.syncKeepAliveParams()
 		e.keepalive.Unlock()
 		e.resetKeepaliveTimer(true /* receivedData */)
 		e.UnlockUser()
@@ -1906,6 +1981,45 @@ func (e *endpoint) HasNIC(id int32) bool {
 	return id == 0 || e.stack.HasNIC(tcpip.NICID(id))
 }
 
+// NICIDForName implements tcpip.SocketOptionsHandler.NICIDForName.
+func (e *endpoint) NICIDForName(name string) (int32, bool) {
+	id, ok := e.stack.NICIDForName(name)
+	return int32(id), ok
+}
+
+// NICName implements tcpip.SocketOptionsHandler.NICName.
+func (e *endpoint) NICName(id int32) (string, bool) {
+	return e.stack.NICName(tcpip.NICID(id))
+}
+
+// InInitialState implements tcpip.SocketOptionsHandler.InInitialState.
+func (e *endpoint) InInitialState() bool {
+	return e.EndpointState() == StateInitial
+}
+
+// OnBoundNICRemoved implements tcpip.SocketOptionsHandler.OnBoundNICRemoved.
+func (e *endpoint) OnBoundNICRemoved(int32) {
+	e.LockUser()
+	defer e.UnlockUser()
+
+	// The connection can no longer be routed, so fail it.
+	if e.EndpointState().connected() {
+		e.resetConnectionLocked(&tcpip.ErrNetworkDown{})
+		e.waiterQueue.Notify(waiter.EventHUp | waiter.EventErr | waiter.ReadableEvents | waiter.WritableEvents)
+	}
+}
+
+// PathMTU implements tcpip.SocketOptionsHandler.PathMTU.
+func (e *endpoint) PathMTU() (uint32, tcpip.Error) {
+	e.LockUser()
+	defer e.UnlockUser()
+
+	if !e.EndpointState().connected() || e.route == nil {
+		return 0, &tcpip.ErrNotConnected{}
+	}
+	return e.route.LinkMTU(), nil
+}
+
 // SetSockOpt sets a socket option.
 func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 	switch v := opt.(type) {
@@ -1913,6 +2027,7 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 		e.LockUser()
 		e.keepalive.Lock()
 		e.keepalive.idle = time.Duration(*v)
+		e.syncKeepAliveParams()
 		e.keepalive.Unlock()
 		e.resetKeepaliveTimer(true /* receivedData */)
 		e.UnlockUser()
@@ -1921,6 +2036,7 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 		e.LockUser()
 		e.keepalive.Lock()
 		e.keepalive.interval = time.Duration(*v)
+		e.syncKeepAliveParams()
 		e.keepalive.Unlock()
 		e.resetKeepaliveTimer(true /* receivedData */)
 		e.UnlockUser()
@@ -1990,9 +2106,6 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 		e.deferAccept = time.Duration(*v)
 		e.UnlockUser()
 
-	case *tcpip.SocketDetachFilterOption:
-		return nil
-
 	default:
 		return nil
 	}
@@ -2843,24 +2956,25 @@ func (e *endpoint) onICMPError(err tcpip.Error, transErr stack.TransportError, p
 	}
 
 	if recvErr {
-		e.SocketOptions().QueueErr(&tcpip.SockError{
-			Err:   err,
-			Cause: transErr,
-			// Linux passes the payload with the TCP header. We don't know if the TCP
-			// header even exists, it may not for fragmented packets.
-			Payload: pkt.Data().AsRange().ToView(),
-			Dst: tcpip.FullAddress{
+		e.SocketOptions().QueueICMPErrWithOffender(
+			err,
+			pkt.NetworkProtocolNumber,
+			pkt.NICID,
+			transErr,
+			tcpip.FullAddress{
 				NIC:  pkt.NICID,
 				Addr: e.TransportEndpointInfo.ID.RemoteAddress,
 				Port: e.TransportEndpointInfo.ID.RemotePort,
 			},
-			Offender: tcpip.FullAddress{
+			tcpip.FullAddress{
 				NIC:  pkt.NICID,
 				Addr: e.TransportEndpointInfo.ID.LocalAddress,
 				Port: e.TransportEndpointInfo.ID.LocalPort,
 			},
-			NetProto: pkt.NetworkProtocolNumber,
-		})
+			// Linux passes the payload with the TCP header. We don't know if the TCP
+			// header even exists, it may not for fragmented packets.
+			pkt.Data().AsRange().ToView(),
+		)
 	}
 
 	if e.EndpointState().connecting() {
@@ -2930,6 +3044,7 @@ func (e *endpoint) updateSndBufferUsage(v int) {
 	e.sndQueueInfo.sndQueueMu.Lock()
 	notify := e.sndQueueInfo.SndBufUsed >= sendBufferSize>>1
 	e.sndQueueInfo.SndBufUsed -= v
+	e.ops.UpdateSendBufferUsage(int64(e.sndQueueInfo.SndBufUsed))
 
 	// Get the new send buffer size with auto tuning, but do not set it
 	// unless we decide to notify the writers.
@@ -475,6 +475,14 @@ func (p *protocol) Option(option tcpip.GettableTransportProtocolOption) tcpip.Er
 		p.mu.RUnlock()
 		return nil
 
+	case *tcpip.TCPKeepaliveDefaultsOption:
+		*v = tcpip.TCPKeepaliveDefaultsOption{
+			Idle:     DefaultKeepaliveIdle,
+			Interval: DefaultKeepaliveInterval,
+			Count:    DefaultKeepaliveCount,
+		}
+		return nil
+
 	default:
 		return &tcpip.ErrUnknownProtocolOption{}
 	}
@@ -506,6 +506,11 @@ func (r *receiver) handleRcvdSegment(s *segment) (drop bool, err tcpip.Error) {
 	// if required.
 	if segLen > 0 {
 		r.updateRTT()
+
+		// In quick-ACK mode the data is acknowledged right away.
+		if r.ep.quickAck {
+			r.ep.sendQuickAck()
+		}
 	}
 
 	// By consuming the current segment, we may have filled a gap in the
@@ -971,6 +971,64 @@ func TestSimpleReceive(t *testing.T) {
 	)
 }
 
+func TestQuickAckConsumedByAck(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		setQuickAck  bool
+		wantQuickAck bool
+	}{
+		{
+			// The default is not consumed by ACKs.
+			name:         "default",
+			setQuickAck:  false,
+			wantQuickAck: true,
+		},
+		{
+			name:         "set after connect",
+			setQuickAck:  true,
+			wantQuickAck: false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, e2e.DefaultMTU)
+			defer c.Cleanup()
+
+			c.CreateConnected(context.TestInitialSequenceNumber, 30000, -1 /* epRcvBuf */)
+
+			if test.setQuickAck {
+				if err := c.EP.SocketOptions().SetQuickAck(true); err != nil {
+					t.Fatalf("SetQuickAck(true): %s", err)
+				}
+			}
+
+			data := []byte{1, 2, 3}
+			iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+			c.SendPacket(data, &context.Headers{
+				SrcPort: context.TestPort,
+				DstPort: c.Port,
+				Flags:   header.TCPFlagAck,
+				SeqNum:  iss,
+				AckNum:  c.IRS.Add(1),
+				RcvWnd:  30000,
+			})
+
+			b := c.GetPacket()
+			defer b.Release()
+			checker.IPv4(t, b,
+				checker.TCP(
+					checker.DstPort(context.TestPort),
+					checker.TCPAckNum(uint32(iss)+uint32(len(data))),
+					checker.TCPFlags(header.TCPFlagAck),
+				),
+			)
+
+			if got := c.EP.SocketOptions().GetQuickAck(); got != test.wantQuickAck {
+				t.Errorf("got GetQuickAck() = %t after an ACK was sent, want = %t", got, test.wantQuickAck)
+			}
+		})
+	}
+}
+
 // TestUserSuppliedMSSOnConnect tests that the user supplied MSS is used when
 // creating a new active TCP socket. It should be present in the sent TCP
 // SYN segment.
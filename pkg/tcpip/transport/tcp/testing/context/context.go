@@ -613,7 +613,9 @@ func (c *Context) CreateV6Endpoint(v6only bool) {
 		c.t.Fatalf("NewEndpoint failed: %v", err)
 	}
 
-	c.EP.SocketOptions().SetV6Only(v6only)
+	if err := c.EP.SocketOptions().SetV6Only(v6only); err != nil {
+		c.t.Fatalf("SetV6Only(%t) failed: %s", v6only, err)
+	}
 }
 
 // GetV6Packet reads a single packet from the link layer endpoint of the context
@@ -179,7 +179,9 @@ func (c *Context) CreateEndpointForFlow(flow TestFlow, transport tcpip.Transport
 
 	c.CreateEndpoint(flow.SockProto(), transport)
 	if flow.isV6Only() {
-		c.EP.SocketOptions().SetV6Only(true)
+		if err := c.EP.SocketOptions().SetV6Only(true); err != nil {
+			c.T.Fatalf("SetV6Only(true) failed: %s", err)
+		}
 	} else if flow.isBroadcast() {
 		c.EP.SocketOptions().SetBroadcast(true)
 	}
@@ -203,7 +205,9 @@ func (c *Context) CreateRawEndpointForFlow(flow TestFlow, transport tcpip.Transp
 
 	c.CreateRawEndpoint(flow.SockProto(), transport)
 	if flow.isV6Only() {
-		c.EP.SocketOptions().SetV6Only(true)
+		if err := c.EP.SocketOptions().SetV6Only(true); err != nil {
+			c.T.Fatalf("SetV6Only(true) failed: %s", err)
+		}
 	} else if flow.isBroadcast() {
 		c.EP.SocketOptions().SetBroadcast(true)
 	}
@@ -114,6 +114,7 @@ func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, waiterQue
 		uniqueID:    s.UniqueID(),
 	}
 	e.ops.InitHandler(e, e.stack, tcpip.GetStackSendBufferLimits, tcpip.GetStackReceiveBufferLimits)
+	e.ops.SetTransportProtocol(ProtocolNumber)
 	e.ops.SetMulticastLoop(true)
 	e.ops.SetSendBufferSize(32*1024, false /* notify */)
 	e.ops.SetReceiveBufferSize(32*1024, false /* notify */)
@@ -250,31 +251,32 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 		HasTimestamp: true,
 		Timestamp:    p.receivedAt,
 	}
+	cmsgs := e.ops.ReceiveCmsgMask()
 	switch p.netProto {
 	case header.IPv4ProtocolNumber:
-		if e.ops.GetReceiveTOS() {
+		if cmsgs.Has(tcpip.CmsgMaskTOS) {
 			cm.HasTOS = true
 			cm.TOS = p.tosOrTClass
 		}
-		if e.ops.GetReceiveTTL() {
+		if cmsgs.Has(tcpip.CmsgMaskTTL) {
 			cm.HasTTL = true
 			cm.TTL = p.ttlOrHopLimit
 		}
-		if e.ops.GetReceivePacketInfo() {
+		if cmsgs.Has(tcpip.CmsgMaskPacketInfo) {
 			cm.HasIPPacketInfo = true
 			cm.PacketInfo = p.packetInfo
 		}
 	case header.IPv6ProtocolNumber:
-		if e.ops.GetReceiveTClass() {
+		if cmsgs.Has(tcpip.CmsgMaskTClass) {
 			cm.HasTClass = true
 			// Although TClass is an 8-bit value it's read in the CMsg as a uint32.
 			cm.TClass = uint32(p.tosOrTClass)
 		}
-		if e.ops.GetReceiveHopLimit() {
+		if cmsgs.Has(tcpip.CmsgMaskHopLimit) {
 			cm.HasHopLimit = true
 			cm.HopLimit = p.ttlOrHopLimit
 		}
-		if e.ops.GetIPv6ReceivePacketInfo() {
+		if cmsgs.Has(tcpip.CmsgMaskIPv6PacketInfo) {
 			cm.HasIPv6PacketInfo = true
 			cm.IPv6PacketInfo = tcpip.IPv6PacketInfo{
 				NIC:  p.packetInfo.NIC,
@@ -285,9 +287,10 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 		panic(fmt.Sprintf("unrecognized network protocol = %d", p.netProto))
 	}
 
-	if e.ops.GetReceiveOriginalDstAddress() {
+	if cmsgs.Has(tcpip.CmsgMaskOriginalDstAddress) {
 		cm.HasOriginalDstAddress = true
 		cm.OriginalDstAddress = p.destinationAddress
+		e.ops.RecordOriginalDstAddress(p.destinationAddress)
 	}
 
 	// Read Result
@@ -562,6 +565,33 @@ func (e *endpoint) HasNIC(id int32) bool {
 	return e.stack.HasNIC(tcpip.NICID(id))
 }
 
+// NICIDForName implements tcpip.SocketOptionsHandler.NICIDForName.
+func (e *endpoint) NICIDForName(name string) (int32, bool) {
+	id, ok := e.stack.NICIDForName(name)
+	return int32(id), ok
+}
+
+// NICName implements tcpip.SocketOptionsHandler.NICName.
+func (e *endpoint) NICName(id int32) (string, bool) {
+	return e.stack.NICName(tcpip.NICID(id))
+}
+
+// InInitialState implements tcpip.SocketOptionsHandler.InInitialState.
+func (e *endpoint) InInitialState() bool {
+	return e.net.State() == transport.DatagramEndpointStateInitial
+}
+
+// PathMTU implements tcpip.SocketOptionsHandler.PathMTU.
+func (e *endpoint) PathMTU() (uint32, tcpip.Error) {
+	return e.net.PathMTU()
+}
+
+// OnBoundNICRemoved implements tcpip.SocketOptionsHandler.OnBoundNICRemoved.
+func (e *endpoint) OnBoundNICRemoved(int32) {
+	e.UpdateLastError(&tcpip.ErrNetworkDown{})
+	e.waiterQueue.Notify(waiter.EventErr)
+}
+
 // SetSockOpt implements tcpip.Endpoint.
 func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 	return e.net.SetSockOpt(opt)
@@ -930,6 +960,12 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt stack.PacketBu
 		return
 	}
 
+	if dst := netHdr.DestinationAddress(); (header.IsV4MulticastAddress(dst) || header.IsV6MulticastAddress(dst)) && !e.net.AcceptsMulticast(pkt.NICID, dst) {
+		// The endpoint has IP_MULTICAST_ALL disabled and did not join the
+		// group on the receiving interface.
+		return
+	}
+
 	e.stack.Stats().UDP.PacketsReceived.Increment()
 	e.stats.PacketsReceived.Increment()
 
@@ -1022,22 +1058,23 @@ func (e *endpoint) onICMPError(err tcpip.Error, transErr stack.TransportError, p
 		}
 
 		id := e.net.Info().ID
-		e.SocketOptions().QueueErr(&tcpip.SockError{
-			Err:     err,
-			Cause:   transErr,
-			Payload: payload,
-			Dst: tcpip.FullAddress{
+		e.SocketOptions().QueueICMPErrWithOffender(
+			err,
+			pkt.NetworkProtocolNumber,
+			pkt.NICID,
+			transErr,
+			tcpip.FullAddress{
 				NIC:  pkt.NICID,
 				Addr: id.RemoteAddress,
 				Port: e.remotePort,
 			},
-			Offender: tcpip.FullAddress{
+			tcpip.FullAddress{
 				NIC:  pkt.NICID,
 				Addr: id.LocalAddress,
 				Port: e.localPort,
 			},
-			NetProto: pkt.NetworkProtocolNumber,
-		})
+			payload,
+		)
 	}
 
 	// Notify of the error.
@@ -142,6 +142,29 @@ func TestBindEphemeralPort(t *testing.T) {
 	}
 }
 
+func TestPathMTU(t *testing.T) {
+	c := context.New(t, []stack.TransportProtocolFactory{udp.NewProtocol, icmp.NewProtocol6, icmp.NewProtocol4})
+	defer c.Cleanup()
+
+	c.CreateEndpoint(ipv6.ProtocolNumber, udp.ProtocolNumber)
+
+	if _, err := c.EP.SocketOptions().GetPathMTU(); err != (&tcpip.ErrNotConnected{}) {
+		t.Errorf("got GetPathMTU() = (_, %v) before Connect, want = (_, %s)", err, &tcpip.ErrNotConnected{})
+	}
+
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestV6Addr, Port: context.TestPort}); err != nil {
+		c.T.Fatalf("Connect failed: %s", err)
+	}
+
+	mtu, err := c.EP.SocketOptions().GetPathMTU()
+	if err != nil {
+		t.Fatalf("GetPathMTU(): %s", err)
+	}
+	if mtu != context.DefaultMTU {
+		t.Errorf("got GetPathMTU() = %d, want = %d", mtu, context.DefaultMTU)
+	}
+}
+
 func TestBindReservedPort(t *testing.T) {
 	c := context.New(t, []stack.TransportProtocolFactory{udp.NewProtocol, icmp.NewProtocol6, icmp.NewProtocol4})
 	defer c.Cleanup()
@@ -358,6 +381,51 @@ func TestReadOnBoundToMulticast(t *testing.T) {
 	}
 }
 
+// TestReadWithMulticastAllDisabled checks that an endpoint with
+// IP_MULTICAST_ALL disabled only receives packets for groups it joined itself.
+func TestReadWithMulticastAllDisabled(t *testing.T) {
+	for _, flow := range []context.TestFlow{context.MulticastV4, context.MulticastV6, context.MulticastV6Only} {
+		t.Run(fmt.Sprintf("flow:%s", flow), func(t *testing.T) {
+			c := context.New(t, []stack.TransportProtocolFactory{udp.NewProtocol, icmp.NewProtocol6, icmp.NewProtocol4})
+			defer c.Cleanup()
+
+			mcastAddr := flow.MapAddrIfApplicable(flow.GetMulticastAddr())
+
+			// Another endpoint joins the group, so the NIC accepts its packets.
+			var wq waiter.Queue
+			other, err := c.Stack.NewEndpoint(udp.ProtocolNumber, flow.NetProto(), &wq)
+			if err != nil {
+				c.T.Fatalf("NewEndpoint failed: %s", err)
+			}
+			defer other.Close()
+			joinOpt := tcpip.AddMembershipOption{NIC: 1, MulticastAddr: flow.GetMulticastAddr()}
+			if err := other.SetSockOpt(&joinOpt); err != nil {
+				c.T.Fatalf("other.SetSockOpt(&%#v): %s", joinOpt, err)
+			}
+
+			c.CreateEndpointForFlow(flow, udp.ProtocolNumber)
+			if err := c.EP.Bind(tcpip.FullAddress{Addr: mcastAddr, Port: context.StackPort}); err != nil {
+				c.T.Fatalf("Bind failed: %s", err)
+			}
+
+			if !c.EP.SocketOptions().GetMulticastAll() {
+				c.T.Fatal("got GetMulticastAll() = false on a new endpoint, want = true")
+			}
+			testRead(c, flow)
+
+			c.EP.SocketOptions().SetMulticastAll(false)
+			testFailingRead(c, flow, false /* expectReadError */)
+
+			// Joining the group delivers its packets again.
+			ifoptSet := tcpip.AddMembershipOption{NIC: 1, MulticastAddr: mcastAddr}
+			if err := c.EP.SetSockOpt(&ifoptSet); err != nil {
+				c.T.Fatalf("SetSockOpt(&%#v): %s", ifoptSet, err)
+			}
+			testRead(c, flow)
+		})
+	}
+}
+
 // TestV4ReadOnBoundToBroadcast checks that an endpoint can bind to a broadcast
 // address and can receive only broadcast data.
 func TestV4ReadOnBoundToBroadcast(t *testing.T) {
@@ -1485,12 +1553,16 @@ func TestMulticastInterfaceOption(t *testing.T) {
 
 							// Verify multicast interface addr and NIC were set correctly.
 							// Note that NIC must be 1 since this is our outgoing interface.
+							ifoptWant := tcpip.MulticastInterfaceOption{NIC: 1, InterfaceAddr: ifoptSet.InterfaceAddr}
 							var ifoptGot tcpip.MulticastInterfaceOption
 							if err := c.EP.GetSockOpt(&ifoptGot); err != nil {
 								c.T.Fatalf("GetSockOpt(&%T): %s", ifoptGot, err)
-							} else if ifoptWant := (tcpip.MulticastInterfaceOption{NIC: 1, InterfaceAddr: ifoptSet.InterfaceAddr}); ifoptGot != ifoptWant {
+							} else if ifoptGot != ifoptWant {
 								c.T.Errorf("got multicast interface option = %#v, want = %#v", ifoptGot, ifoptWant)
 							}
+							if got := c.EP.SocketOptions().GetMulticastInterface(); got != ifoptWant {
+								c.T.Errorf("got c.EP.SocketOptions().GetMulticastInterface() = %#v, want = %#v", got, ifoptWant)
+							}
 						})
 					}
 				})